@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// privateIFD0Tags lists the IFD0 fields -anonymize removes: Artist,
+// HostComputer, Copyright and the GPS IFD pointer.
+var privateIFD0Tags = map[uint16]bool{
+	0x013b:    true,
+	0x013c:    true,
+	0x8298:    true,
+	tagGPSIFD: true,
+}
+
+// privateExifTags lists the EXIF IFD fields -anonymize removes: MakerNote,
+// UserComment, ImageUniqueID, CameraOwnerName, BodySerialNumber and
+// LensSerialNumber.
+var privateExifTags = map[uint16]bool{
+	0x927c: true,
+	0x9286: true,
+	0xa420: true,
+	0xa430: true,
+	0xa431: true,
+	0xa435: true,
+}
+
+// renderingVendors lists the APPn segments -anonymize keeps, as named by
+// appVendor, besides EXIF and JFIF which it cleans.
+var renderingVendors = map[string]bool{
+	"ICC_PROFILE":  true,
+	"Adobe":        true,
+	"MPF":          true,
+	"HDR gain map": true,
+}
+
+// clear zeroes the value of e.
+func (t *tiff) clear(e ifdEntry) {
+	v := t.value(e)
+	for i := range v {
+		v[i] = 0
+	}
+}
+
+// clearIFD zeroes the IFD at off and the values of its entries.
+func (t *tiff) clearIFD(off int) {
+	entries, _, err := t.ifd(off)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		t.clear(e)
+	}
+	end := off + 2 + 12*int(t.order.Uint16(t.data[off:])) + 4
+	if end > len(t.data) {
+		end = len(t.data)
+	}
+	for i := off; i < end; i++ {
+		t.data[i] = 0
+	}
+}
+
+// remove deletes from the IFD at off the entries whose tag is in tags,
+// zeroing their values, and returns how many it removed. The directory
+// shrinks in place, so no other value moves.
+func (t *tiff) remove(off int, tags map[uint16]bool) int {
+	entries, _, err := t.ifd(off)
+	if err != nil {
+		return 0
+	}
+	for _, e := range entries {
+		if tags[e.tag] {
+			t.clear(e)
+		}
+	}
+	n := int(t.order.Uint16(t.data[off:]))
+	kept := 0
+	for i := 0; i < n; i++ {
+		e := t.data[off+2+12*i : off+2+12*i+12]
+		if tags[t.order.Uint16(e)] {
+			continue
+		}
+		copy(t.data[off+2+12*kept:], e)
+		kept++
+	}
+	// Move the next IFD offset up behind the kept entries.
+	end := off + 2 + 12*n
+	tail := 0
+	if end+4 <= len(t.data) {
+		tail = 4
+	}
+	copy(t.data[off+2+12*kept:], t.data[end:end+tail])
+	for i := off + 2 + 12*kept + tail; i < end+tail; i++ {
+		t.data[i] = 0
+	}
+	t.order.PutUint16(t.data[off:], uint16(kept))
+	return n - kept
+}
+
+// unlink sets the next IFD offset of the IFD at off to zero.
+func (t *tiff) unlink(off int) {
+	end := off + 2 + 12*int(t.order.Uint16(t.data[off:]))
+	if end+4 <= len(t.data) {
+		t.order.PutUint32(t.data[end:], 0)
+	}
+}
+
+// anonymizeExif returns a copy of the EXIF payload p without its GPS
+// data, thumbnail and the fields identifying the owner or the camera, and
+// the number of fields removed. The payload keeps its size.
+func anonymizeExif(p []byte) ([]byte, int, error) {
+	p = append([]byte(nil), p...)
+	x, err := parseExif(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := 0
+	if e, ok := lookup(x.ifd0, tagGPSIFD); ok {
+		if off, ok := x.uint(e, 0); ok {
+			x.clearIFD(int(off))
+		}
+	}
+	if len(x.ifd1) > 0 {
+		if data := x.thumbnail(); data != nil {
+			for i := range data {
+				data[i] = 0
+			}
+		}
+		_, next, _ := x.ifd(x.first())
+		x.clearIFD(next)
+		n++
+	}
+	if e, ok := lookup(x.ifd0, tagExifIFD); ok {
+		if off, ok := x.uint(e, 0); ok {
+			n += x.remove(int(off), privateExifTags)
+		}
+	}
+	n += x.remove(x.first(), privateIFD0Tags)
+	x.unlink(x.first())
+	return p, n, nil
+}
+
+// anonymizeJFIF returns a copy of the JFIF payload p without its
+// thumbnail, if it has one.
+func anonymizeJFIF(p []byte) ([]byte, bool) {
+	if len(p) < 14 || len(p) == 14 && p[12] == 0 && p[13] == 0 {
+		return p, false
+	}
+	p = append([]byte(nil), p[:14]...)
+	p[12], p[13] = 0, 0
+	return p, true
+}
+
+// anonymizeFile copies the JPEG stream r to the file out without the
+// metadata that could identify the author, the camera or the place: GPS
+// data, serial numbers, owner names, thumbnails, XMP, IPTC, comments and
+// unknown APPn segments. The orientation, the ICC profile and the Adobe
+// segment are kept, as renderers need them.
+func anonymizeFile(file string, r Reader, out string) (err error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(f, &err)
+	cp := newCopier(file, f)
+	var n, size, cleaned, fields int
+	cp.ps.handlers = append(cp.ps.handlers, func(m marker, p []byte) {
+		if m.sym != COM && (m.sym < APP0 || m.sym > APP0+15) {
+			cp.add(m.sym, p)
+			return
+		}
+		var clean []byte
+		switch v := appVendor(p); {
+		case m.sym == COM:
+		case v == "Exif":
+			if q, k, err := anonymizeExif(p); err == nil {
+				clean = q
+				fields += k
+			}
+		case v == "JFIF" && m.sym == APP0:
+			clean, _ = anonymizeJFIF(p)
+		case renderingVendors[v]:
+			cp.add(m.sym, p)
+			return
+		}
+		switch {
+		case clean == nil:
+			n++
+			size += 4 + len(p)
+		case !bytes.Equal(clean, p):
+			cleaned++
+			size += len(p) - len(clean)
+			cp.add(m.sym, clean)
+		default:
+			cp.add(m.sym, p)
+		}
+	})
+	if err := cp.copy(r); err != nil {
+		return err
+	}
+	fmt.Printf("%s: removed %d segments and %d EXIF fields, cleaned %d segments, %d bytes smaller, wrote %s\n", displayName(file), n, fields, cleaned, size, out)
+	return nil
+}
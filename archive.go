@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+// archiveSep separates the name of an archive from the name of an entry
+// in the file names reported for archive entries.
+const archiveSep = "!"
+
+// archiveKind returns "zip", "tar" or "tgz" if file is an archive whose
+// entries are scanned, or "" otherwise.
+func archiveKind(file string) string {
+	name := strings.ToLower(file)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tgz"), strings.HasSuffix(name, ".tar.gz"):
+		return "tgz"
+	}
+	return ""
+}
+
+// processArchive runs printInfo on the entries of an archive whose
+// extension is in the -ext list, naming them archive!entry.
+func processArchive(file string, c config, w io.Writer, onError func(string, error)) error {
+	f, err := openInput(file)
+	if err != nil {
+		return openError{err}
+	}
+	defer f.Close()
+	exts := parseExts(c.exts)
+	entry := func(name string, r io.Reader, size int64) {
+		if !hasExt(name, exts) {
+			return
+		}
+		name = file + archiveSep + name
+		if err := processInput(name, bufio.NewReader(r), size, c, w); err != nil {
+			onError(name, err)
+		}
+	}
+	if archiveKind(file) == "zip" {
+		return walkZip(f, entry)
+	}
+	r := io.Reader(f)
+	if archiveKind(file) == "tgz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return walkTar(r, entry)
+}
+
+// walkZip calls fn on each regular file of a zip archive. The central
+// directory is at the end, so archives not read from a local file are
+// read into memory first, up to the read limit.
+func walkZip(f io.Reader, fn func(string, io.Reader, int64)) error {
+	var ra io.ReaderAt
+	var size int64
+	if osf, ok := f.(*os.File); ok {
+		fi, err := osf.Stat()
+		if err != nil {
+			return err
+		}
+		ra, size = osf, fi.Size()
+	} else {
+		data, err := readAll(f)
+		if err != nil {
+			return err
+		}
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		fn(zf.Name, rc, int64(zf.UncompressedSize64))
+		rc.Close()
+	}
+	return nil
+}
+
+// walkTar calls fn on each regular file of a tar archive.
+func walkTar(r io.Reader, fn func(string, io.Reader, int64)) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.FileInfo().Mode().IsRegular() {
+			fn(h.Name, tr, h.Size)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestWalkZipLimit(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(encodeGray(t, 16, 16))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	defer func(n int64) { limits.read = n }(limits.read)
+	for _, tt := range []struct {
+		read  int64
+		err   error
+		files int
+	}{
+		{0, nil, 1},
+		{int64(buf.Len()), nil, 1},
+		{int64(buf.Len() - 1), ErrLimit, 0},
+	} {
+		limits.read = tt.read
+		n := 0
+		err := walkZip(bytes.NewReader(buf.Bytes()), func(string, io.Reader, int64) { n++ })
+		if err != tt.err {
+			t.Errorf("read limit %d: got error %v, want %v", tt.read, err, tt.err)
+		}
+		if n != tt.files {
+			t.Errorf("read limit %d: got %d files, want %d", tt.read, n, tt.files)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var soiMagic = []byte{0xff, byte(SOI), 0xff}
+
+// carved is a JPEG image found inside another file.
+type carved struct {
+	offset int64
+	size   int64
+	frame  *frame
+}
+
+// validate parses the candidate image starting at off and returns it if
+// it is a complete JPEG with no structural problem.
+func validate(f io.ReaderAt, off, size int64) *carved {
+	ps := newParser(ioutil.Discard)
+	ps.strict = true
+	ps.single = true
+	err := ps.parse(bufio.NewReader(io.NewSectionReader(f, off, size-off)))
+	ps.finish()
+	if ps.result(err) != nil {
+		return nil
+	}
+	return &carved{offset: off, size: int64(ps.offset), frame: ps.fr}
+}
+
+// carve finds the JPEG images embedded in f, which is size bytes long.
+// The images found are skipped, so embedded thumbnails are not reported
+// separately.
+func carve(f io.ReaderAt, size int64) ([]*carved, error) {
+	var images []*carved
+	buf := make([]byte, 1<<20)
+	for pos := int64(0); pos < size; {
+		n, err := f.ReadAt(buf, pos)
+		if err != nil && err != io.EOF {
+			return images, err
+		}
+		i := bytes.Index(buf[:n], soiMagic)
+		if i < 0 {
+			if pos+int64(n) >= size {
+				break
+			}
+			// Keep enough overlap to find a magic across chunks.
+			pos += int64(n - len(soiMagic) + 1)
+			continue
+		}
+		if c := validate(f, pos+int64(i), size); c != nil {
+			images = append(images, c)
+			pos = c.offset + c.size
+		} else {
+			pos += int64(i + 1)
+		}
+	}
+	return images, nil
+}
+
+// carveFile reports the JPEG images embedded in file and, if dir is not
+// empty, writes them there.
+func carveFile(w io.Writer, file, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return openError{err}
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	images, err := carve(f, fi.Size())
+	for _, c := range images {
+		fmt.Fprintf(w, "%s: JPEG at %d, %d bytes", file, c.offset, c.size)
+		if c.frame != nil {
+			fmt.Fprintf(w, ", %dx%d %s", c.frame.width, c.frame.lines(), c.frame.sym.Short())
+		}
+		fmt.Fprintln(w)
+		if dir == "" {
+			continue
+		}
+		data := make([]byte, c.size)
+		if _, err := f.ReadAt(data, c.offset); err != nil {
+			return err
+		}
+		name := filepath.Join(dir, fmt.Sprintf("%s-%d.jpg", filepath.Base(file), c.offset))
+		if err := ioutil.WriteFile(name, data, 0666); err != nil {
+			return err
+		}
+	}
+	return err
+}
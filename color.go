@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI escape sequences used by -color.
+const (
+	colorReset   = "\x1b[0m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorBlue    = "\x1b[34m"
+	colorMagenta = "\x1b[35m"
+	colorCyan    = "\x1b[36m"
+	colorBoldRed = "\x1b[1;31m"
+)
+
+// parseColor resolves a -color mode to whether the output is colorized.
+// auto colorizes when stdout is a terminal and NO_COLOR is not set.
+func parseColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		fi, err := os.Stdout.Stat()
+		return err == nil && fi.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("unknown color mode %q", mode)
+}
+
+// color returns the color of the class of marker s: structure, tables,
+// metadata, scan data, or anomalous for markers that have no business in
+// a JPEG file.
+func (s symbol) color() string {
+	switch {
+	case s == SOS, s.isRST():
+		return colorMagenta
+	case s == DQT, s == DHT, s == DAC, s == LSE:
+		return colorCyan
+	case APP0 <= s && s <= APP0+15, s == COM:
+		return colorGreen
+	case s == SOI, s == EOI, s.isSOF(), s == DRI, s == DNL, s == DHP, s == EXP:
+		return colorBlue
+	}
+	return colorBoldRed
+}
+
+// paint wraps text in color if on is set.
+func paint(on bool, color, text string) string {
+	if !on {
+		return text
+	}
+	return color + text + colorReset
+}
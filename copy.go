@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/dlecorfec/dumpjpeg/jpegseg"
+)
+
+// copier copies a JPEG stream to a file through a jpegseg.Builder, as the
+// parser reads it. The handlers of its parser choose, with add, the
+// segments written; the entropy-coded data of the scans and the data
+// trailing the last image are copied as they are.
+//
+// Removing or inserting segments moves the images that follow, so the
+// copier keeps track of where each image goes, and updates the MP index
+// of MPF files once the output is written.
+type copier struct {
+	file   string
+	ps     *parser
+	b      *jpegseg.Builder
+	w      *bufio.Writer
+	f      *os.File
+	images []placement
+	index  *mpfCopy
+}
+
+// placement tells where an image read at offset in went in the output,
+// from out to end. end is 0 until its EOI is written.
+type placement struct {
+	in, out, end int
+}
+
+// mpfCopy is the MPF segment holding the MP index, as written.
+type mpfCopy struct {
+	payload []byte
+	out     int // offset of the payload in the output
+	image   int // index in copier.images of the image holding it
+	entries []mpEntry
+}
+
+func newCopier(file string, f *os.File) *copier {
+	w := bufio.NewWriter(f)
+	cp := &copier{file: file, ps: newParser(ioutil.Discard), b: jpegseg.NewBuilder(w), w: w, f: f}
+	// Every payload is needed, whatever its size.
+	cp.ps.limits.segment = 0
+	cp.ps.scanData = cp.b
+	cp.ps.trailerData = cp.b
+	return cp
+}
+
+// add writes the segment with marker sym and payload p. RST markers are
+// part of the entropy-coded data, which is copied already.
+func (cp *copier) add(sym symbol, p []byte) {
+	if sym.isRST() {
+		return
+	}
+	out := int(cp.b.Offset())
+	if cp.b.Add(jpegseg.Segment{Marker: byte(sym), Payload: p}) != nil {
+		return
+	}
+	switch {
+	case sym == SOI:
+		cp.images = append(cp.images, placement{in: cp.ps.start, out: out})
+	case sym == EOI && len(cp.images) > 0:
+		cp.images[len(cp.images)-1].end = int(cp.b.Offset())
+	case sym == APP2 && cp.index == nil && cp.ps.mpf != nil && bytes.HasPrefix(p, mpfHeader):
+		// The parser decoded the index from the input already.
+		cp.index = &mpfCopy{
+			payload: append([]byte(nil), p...),
+			out:     out + 4,
+			image:   len(cp.images) - 1,
+			entries: cp.ps.mpf,
+		}
+	}
+}
+
+// copy parses r, writing the segments its handlers add, and flushes the
+// output. A truncated input yields a truncated output.
+func (cp *copier) copy(r Reader) error {
+	if err := cp.ps.parse(r); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if !cp.ps.magic {
+		return ErrNotJpeg
+	}
+	if err := cp.b.Close(); err != nil && (err != jpegseg.ErrNotEnded || cp.ps.eoi) {
+		return err
+	}
+	if err := cp.w.Flush(); err != nil {
+		return err
+	}
+	return cp.placeMPF()
+}
+
+// placeMPF rewrites the MP index with the offsets and sizes of the images
+// it lists in the output. Entries pointing to the data trailing the last
+// image move with it; others are left as they are.
+func (cp *copier) placeMPF() error {
+	ix := cp.index
+	if ix == nil {
+		return nil
+	}
+	var trailer placement
+	if t := cp.ps.trailer; t != nil && len(cp.images) > 0 {
+		trailer = placement{in: t.offset, out: cp.images[len(cp.images)-1].end}
+	}
+	for i, e := range ix.entries {
+		found := false
+		for j, im := range cp.images {
+			if im.end > 0 && (e.offset == 0 && j == ix.image || e.offset != 0 && e.offset == im.in) {
+				e.size = im.end - im.out
+				if e.offset != 0 {
+					e.offset = im.out
+				}
+				found = true
+			}
+		}
+		if !found && trailer.in > 0 && e.offset >= trailer.in {
+			e.offset += trailer.out - trailer.in
+			found = true
+		}
+		if !found {
+			log.Printf("%s: MPF image #%d not found, its MP entry is left as is", displayName(cp.file), i+1)
+			continue
+		}
+		if err := setMPEntry(ix.payload, ix.out, i, e); err != nil {
+			return err
+		}
+	}
+	_, err := cp.f.WriteAt(ix.payload, int64(ix.out))
+	return err
+}
+
+// closeOutput closes the output file f, removing it if *err is set, so
+// that a failed copy leaves no partial output behind.
+func closeOutput(f *os.File, err *error) {
+	if cerr := f.Close(); *err == nil {
+		*err = cerr
+	}
+	if *err != nil {
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dlecorfec/dumpjpeg/jpegseg"
+)
+
+// exifSegment is an APP1 segment holding an empty EXIF IFD0.
+var exifSegment = jpegseg.AppendSegment(nil, byte(APP1), []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00"))
+
+func TestRoundTrip(t *testing.T) {
+	com := jpegseg.AppendSegment(nil, byte(COM), []byte("taken at home"))
+	primary := insertAfterSOI(encodeGray(t, 64, 48), exifSegment, com)
+	data := buildMPF(primary, insertAfterSOI(encodeGray(t, 32, 24), exifSegment, com))
+	tests := []struct {
+		name  string
+		write func(file string, r Reader, out string) error
+		same  bool // the output is the input
+	}{
+		{
+			name: "strip nothing",
+			write: func(file string, r Reader, out string) error {
+				return stripFile(file, r, out, map[string]bool{"xmp": true}, nil)
+			},
+			same: true,
+		},
+		{
+			name: "strip EXIF and comments",
+			write: func(file string, r Reader, out string) error {
+				return stripFile(file, r, out, map[string]bool{"exif": true, "com": true}, nil)
+			},
+		},
+		{
+			name: "add a comment",
+			write: func(file string, r Reader, out string) error {
+				a, _ := newAddition(COM, []byte("a comment"))
+				return stripFile(file, r, out, nil, additions{a})
+			},
+		},
+		{
+			name: "replace EXIF",
+			write: func(file string, r Reader, out string) error {
+				a, _ := newAddition(APP1, append(append([]byte(nil), exifSegment[4:]...), make([]byte, 100)...))
+				return stripFile(file, r, out, nil, additions{a})
+			},
+		},
+		{
+			name:  "anonymize",
+			write: anonymizeFile,
+		},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(dir, "out.jpg")
+			if err := tt.write("in.jpg", bufio.NewReader(bytes.NewReader(data)), out); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.same != bytes.Equal(got, data) {
+				t.Errorf("got %d bytes from %d, want same=%v", len(got), len(data), tt.same)
+			}
+			ps := parseBytes(got)
+			if len(ps.problems) > 0 {
+				t.Errorf("got problems %v, want none", ps.problems)
+			}
+			checkMPF(t, got, ps.mpf)
+			os.Remove(out)
+		})
+	}
+}
+
+func TestCopyNotJpeg(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(file string, r Reader, out string) error
+	}{
+		{
+			name: "strip",
+			write: func(file string, r Reader, out string) error {
+				return stripFile(file, r, out, map[string]bool{"com": true}, nil)
+			},
+		},
+		{
+			name:  "anonymize",
+			write: anonymizeFile,
+		},
+	}
+	out := filepath.Join(t.TempDir(), "out.jpg")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.write("in.txt", bufio.NewReader(strings.NewReader("not a JPEG\n")), out)
+			if err != ErrNotJpeg {
+				t.Errorf("got error %v, want %v", err, ErrNotJpeg)
+			}
+			if _, err := os.Stat(out); !os.IsNotExist(err) {
+				t.Errorf("output left behind: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+var csvHeader = []string{"file", "image", "marker", "offset", "size", "width", "height", "components", "quality", "interval"}
+
+func newCSVWriter(w io.Writer, format string) *csv.Writer {
+	cw := csv.NewWriter(w)
+	if format == "tsv" {
+		cw.Comma = '\t'
+	}
+	return cw
+}
+
+// csvRow returns the CSV columns for marker m, leaving empty the decoded
+// columns that do not apply.
+func csvRow(file string, m marker, hex bool) []string {
+	row := make([]string, len(csvHeader))
+	row[0] = file
+	row[1] = strconv.Itoa(m.image)
+	row[2] = m.sym.Short()
+	row[3] = formatInt(m.offset-2, hex)
+	row[4] = formatInt(m.size, hex)
+	switch {
+	case m.frame != nil:
+		row[5] = strconv.Itoa(m.frame.width)
+		row[6] = strconv.Itoa(m.frame.height)
+		row[7] = strconv.Itoa(len(m.frame.components))
+	case len(m.quant) > 0:
+		row[8] = strconv.Itoa(m.quant[0].quality())
+	case m.sym == DRI:
+		row[9] = strconv.Itoa(m.interval)
+	case m.sym == DNL:
+		row[6] = strconv.Itoa(m.lines)
+	case m.scan != nil:
+		row[7] = strconv.Itoa(len(m.scan.components))
+	}
+	return row
+}
+
+func formatInt(n int, hex bool) string {
+	if hex {
+		return "0x" + strconv.FormatInt(int64(n), 16)
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+)
+
+// trickleReader returns one byte per Read, so that the bytes read through
+// it tell how far a decoder that buffers its input got.
+type trickleReader struct {
+	data []byte
+	n    int
+}
+
+func (r *trickleReader) Read(p []byte) (int, error) {
+	if r.n >= len(r.data) {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0] = r.data[r.n]
+	r.n++
+	return 1, nil
+}
+
+var ycbcrRatios = map[image.YCbCrSubsampleRatio]string{
+	image.YCbCrSubsampleRatio444: "4:4:4",
+	image.YCbCrSubsampleRatio422: "4:2:2",
+	image.YCbCrSubsampleRatio420: "4:2:0",
+	image.YCbCrSubsampleRatio440: "4:4:0",
+	image.YCbCrSubsampleRatio411: "4:1:1",
+	image.YCbCrSubsampleRatio410: "4:1:0",
+}
+
+// colorModelName describes the pixel format image/jpeg decoded to.
+func colorModelName(img image.Image) string {
+	switch m := img.(type) {
+	case *image.Gray:
+		return "gray"
+	case *image.YCbCr:
+		return "YCbCr " + ycbcrRatios[m.SubsampleRatio]
+	case *image.CMYK:
+		return "CMYK"
+	case *image.RGBA:
+		return "RGB"
+	}
+	return fmt.Sprintf("%T", img)
+}
+
+// blame describes the part of the stream the decoder failed in, from the
+// markers found by the structural parse, given the offset of the last byte
+// it read. The decoder reads a marker and its length field before checking
+// either, so the segment to blame is the one 3 bytes before, unless it
+// just read a marker without length, such as EOI.
+func blame(markers []marker, off int) string {
+	at := off - 3
+	var last *marker
+	for i := range markers {
+		m := &markers[i]
+		if !m.sym.hasLength() && m.offset-1 == off {
+			last, at = m, m.offset-2
+			break
+		}
+		if m.offset-2 > at {
+			break
+		}
+		last = m
+	}
+	switch {
+	case last == nil:
+		return fmt.Sprintf("at offset %d, before the first marker", off)
+	case at < last.end:
+		return fmt.Sprintf("at offset %d, in %s segment at %d", off, last.sym.Short(), last.offset-2)
+	case last.sym == SOS || last.sym.isRST():
+		return fmt.Sprintf("at offset %d, in the entropy-coded data following %s at %d", off, last.sym.Short(), last.offset-2)
+	}
+	return fmt.Sprintf("at offset %d, after %s segment at %d", off, last.sym.Short(), last.offset-2)
+}
+
+// printDecodeCheck decodes the first image of file with image/jpeg,
+// printing its size and color model, or why it failed and where. Files
+// with structural problems image/jpeg tolerates pass the check.
+func printDecodeCheck(file string, r Reader, w io.Writer) error {
+	data, err := readAll(r)
+	if err != nil {
+		return err
+	}
+	ps := newParser(ioutil.Discard)
+	ps.single = true
+	err = ps.parse(bufio.NewReader(bytes.NewReader(data)))
+	ps.finish()
+	err = ps.result(err)
+	if err != nil && err != ErrInvalid {
+		return err
+	}
+
+	tr := &trickleReader{data: data}
+	img, derr := jpeg.Decode(tr)
+	name := displayName(file)
+	if derr != nil {
+		where := blame(ps.markers, tr.n-1)
+		if tr.n == len(data) && (derr == io.EOF || derr == io.ErrUnexpectedEOF) {
+			where = "at the end of the file"
+			if t := ps.truncation(); t != "" {
+				where += ": " + t
+			}
+		}
+		fmt.Fprintf(w, "%s: image/jpeg fails: %v, %s\n", name, derr, where)
+		return ErrInvalid
+	}
+	b := img.Bounds()
+	fmt.Fprintf(w, "%s: image/jpeg decodes %dx%d %s\n", name, b.Dx(), b.Dy(), colorModelName(img))
+	return nil
+}
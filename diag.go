@@ -0,0 +1,80 @@
+package main
+
+// Diagnostic codes, stable identifiers of the kinds of problems found
+// while parsing, for tools that consume the -check or JSON output.
+const (
+	diagNotJpeg        = "not-jpeg"
+	diagNoSOI          = "no-soi"
+	diagMisplacedSOI   = "misplaced-soi"
+	diagMisplacedRST   = "misplaced-rst"
+	diagReservedMarker = "reserved-marker"
+	diagGarbage        = "garbage"
+	diagBadLength      = "bad-length"
+	diagLengthOverflow = "length-overflow"
+	diagTruncated      = "truncated"
+	diagBadSegment     = "bad-segment"
+	diagBadMetadata    = "bad-metadata"
+	diagFrame          = "bad-frame"
+	diagNoFrame        = "no-frame"
+	diagBadScan        = "bad-scan"
+	diagStuffing       = "bad-stuffing"
+	diagRestart        = "bad-restart"
+	diagCorruptData    = "corrupt-data"
+	diagExtraData      = "extra-data"
+	diagLimit          = "limit"
+	diagLargeSegment   = "large-segment"
+)
+
+// severity tells whether a problem makes the file invalid (error) or is
+// only worth knowing about (warning).
+type severity int
+
+const (
+	sevWarning severity = iota
+	sevError
+)
+
+func (s severity) String() string {
+	if s == sevWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// warnings lists the codes of the problems that do not make the file
+// invalid; all others are errors.
+var warnings = map[string]bool{
+	diagReservedMarker: true,
+	diagLargeSegment:   true,
+	diagExtraData:      true,
+}
+
+// problem is a structural defect found while parsing.
+type problem struct {
+	code     string
+	offset   int
+	msg      string
+	severity severity
+}
+
+// Diagnostic is the data model of a problem, as exposed to JSON.
+type Diagnostic struct {
+	Code     string `json:"code"`
+	Offset   int    `json:"offset"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+}
+
+func newDiagnostic(p problem) Diagnostic {
+	return Diagnostic{p.code, p.offset, p.msg, p.severity.String()}
+}
+
+// invalid reports whether any of the problems found so far is an error.
+func (ps *parser) invalid() bool {
+	for _, p := range ps.problems {
+		if p.severity == sevError {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+// digest hashes the data written to it and counts its length.
+type digest struct {
+	hash.Hash
+	n int
+}
+
+func newDigest(h hash.Hash) *digest {
+	return &digest{Hash: h}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.n += len(p)
+	return d.Hash.Write(p)
+}
+
+// diffSegment is a segment of a file being compared, with the digest of
+// the entropy-coded data following it for SOS.
+type diffSegment struct {
+	marker
+	payload []byte
+	data    *digest
+}
+
+// imageData reports whether the segment affects the decoded image.
+func (s *diffSegment) imageData() bool {
+	return s.sym.isSOF() || s.sym == DQT || s.sym == DHT || s.sym == DRI || s.sym == SOS || s.sym == DAC
+}
+
+func readSegments(file string) ([]*diffSegment, error) {
+	f, err := openInput(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var segs []*diffSegment
+	ps := newParser(ioutil.Discard)
+	ps.handlers = append(ps.handlers, func(m marker, p []byte) {
+		if m.sym.isRST() {
+			return
+		}
+		s := &diffSegment{marker: m, payload: p}
+		if m.sym == SOS {
+			s.data = newDigest(sha256.New())
+			ps.scanData = s.data
+			ps.pending = 0
+		}
+		segs = append(segs, s)
+	})
+	err = ps.result(ps.parse(bufio.NewReader(f)))
+	if err == ErrInvalid {
+		err = nil
+	}
+	return segs, err
+}
+
+// align matches the segments of a and b with the same marker, keeping
+// their order, and returns pairs of indexes, -1 standing for a gap.
+func align(a, b []*diffSegment) [][2]int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i].sym == b[j].sym && a[i].image == b[j].image:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var pairs [][2]int
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i].sym == b[j].sym && a[i].image == b[j].image:
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			pairs = append(pairs, [2]int{i, -1})
+			i++
+		default:
+			pairs = append(pairs, [2]int{-1, j})
+			j++
+		}
+	}
+	return pairs
+}
+
+// compare describes how two aligned segments differ, or returns "".
+func compare(a, b *diffSegment) string {
+	switch {
+	case a.sym.isSOF():
+		fa, fb := a.frame, b.frame
+		if fa != nil && fb != nil && (fa.width != fb.width || fa.lines() != fb.lines()) {
+			return fmt.Sprintf("frame %dx%d != %dx%d", fa.width, fa.lines(), fb.width, fb.lines())
+		}
+		if !reflect.DeepEqual(fa, fb) {
+			return "frame header differs"
+		}
+	case a.sym == DQT:
+		var ids []int
+		for _, ta := range a.quant {
+			for _, tb := range b.quant {
+				if ta.id == tb.id && *ta != *tb {
+					ids = append(ids, ta.id)
+				}
+			}
+		}
+		if len(ids) > 0 {
+			return fmt.Sprintf("quantization tables %v differ", ids)
+		}
+	case a.sym == SOS:
+		switch {
+		case bytes.Equal(a.payload, b.payload):
+		case a.scan == nil || b.scan == nil:
+			// A malformed scan header has no script to compare.
+			return "scan header differs"
+		default:
+			return fmt.Sprintf("scan script ss=%d se=%d ah=%d al=%d != ss=%d se=%d ah=%d al=%d",
+				a.scan.ss, a.scan.se, a.scan.ah, a.scan.al, b.scan.ss, b.scan.se, b.scan.ah, b.scan.al)
+		}
+		if !bytes.Equal(a.data.Sum(nil), b.data.Sum(nil)) {
+			return fmt.Sprintf("scan data differs (%d != %d bytes)", a.data.n, b.data.n)
+		}
+		return ""
+	}
+	if a.size != b.size {
+		return fmt.Sprintf("size %d != %d", a.size, b.size)
+	}
+	if !bytes.Equal(a.payload, b.payload) {
+		return "content differs"
+	}
+	return ""
+}
+
+// diffFiles compares the structure of two files and reports whether they
+// differ.
+func diffFiles(w io.Writer, fa, fb string) (bool, error) {
+	a, err := readSegments(fa)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", displayName(fa), err)
+	}
+	b, err := readSegments(fb)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", displayName(fb), err)
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", displayName(fa), displayName(fb))
+	var image, meta int
+	count := func(s *diffSegment) {
+		if s.imageData() {
+			image++
+		} else {
+			meta++
+		}
+	}
+	for _, p := range align(a, b) {
+		switch {
+		case p[1] < 0:
+			s := a[p[0]]
+			count(s)
+			fmt.Fprintf(w, "- %s\tsize %d at %d\n", s.sym.Short(), s.size, s.offset-2)
+		case p[0] < 0:
+			s := b[p[1]]
+			count(s)
+			fmt.Fprintf(w, "+ %s\tsize %d at %d\n", s.sym.Short(), s.size, s.offset-2)
+		default:
+			sa, sb := a[p[0]], b[p[1]]
+			if d := compare(sa, sb); d != "" {
+				count(sa)
+				fmt.Fprintf(w, "~ %s\t%s\n", sa.sym.Short(), d)
+			} else {
+				fmt.Fprintf(w, "  %s\n", sa.sym.Short())
+			}
+		}
+	}
+	if image == 0 {
+		fmt.Fprintf(w, "image data identical, %d metadata differences\n", meta)
+	} else {
+		fmt.Fprintf(w, "image data differs in %d segments, %d metadata differences\n", image, meta)
+	}
+	return image+meta > 0, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	valid := encodeGray(t, 64, 48)
+	malformed := append([]byte(nil), valid...)
+	malformed[sosHeader(t, malformed)] = 4 // components in the scan
+	tests := []struct {
+		name   string
+		a, b   []byte
+		differ bool
+		want   string
+	}{
+		{"identical", valid, valid, false, "image data identical"},
+		{"malformed SOS", valid, malformed, true, "~ SOS\tscan header differs"},
+		{"both malformed", malformed, malformed, false, "image data identical"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fa, fb := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg")
+			if err := ioutil.WriteFile(fa, tt.a, 0666); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(fb, tt.b, 0666); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			differ, err := diffFiles(&out, fa, fb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if differ != tt.differ || !strings.Contains(out.String(), tt.want) {
+				t.Errorf("got differ=%v and\n%s\nwant differ=%v and %q", differ, out.String(), tt.differ, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	ErrBadAddition = errors.New("segment spec must be MARKER=path, MARKER being APPn or COM")
+	ErrNoPlace     = errors.New("no frame or table segment to insert the new segments before")
+)
+
+// addition is a segment to write, in place of the segments with the same
+// marker and vendor identifier, or else after the APPn and COM segments
+// following SOI.
+type addition struct {
+	sym     symbol
+	payload []byte
+	done    bool
+}
+
+func newAddition(sym symbol, payload []byte) (*addition, error) {
+	if sym != COM && (sym < APP0 || sym > APP0+15) {
+		return nil, ErrBadAddition
+	}
+	if len(payload) > 0xffff-2 {
+		return nil, fmt.Errorf("%s payload of %d bytes does not fit in a segment", sym.Short(), len(payload))
+	}
+	return &addition{sym: sym, payload: payload}, nil
+}
+
+// replaces reports whether a takes the place of the segment m with
+// payload p. Comments are always added.
+func (a *addition) replaces(m marker, p []byte) bool {
+	if a.sym == COM || a.sym != m.sym {
+		return false
+	}
+	v := appVendor(a.payload)
+	return v != "" && v == appVendor(p)
+}
+
+// additions implements flag.Value for repeated -add-segment flags. The
+// payloads are read when the flag is parsed.
+type additions []*addition
+
+func (a *additions) String() string {
+	var s []string
+	for _, x := range *a {
+		s = append(s, fmt.Sprintf("%s(%d bytes)", x.sym.Short(), len(x.payload)))
+	}
+	return strings.Join(s, " ")
+}
+
+func (a *additions) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i <= 0 || i == len(v)-1 {
+		return ErrBadAddition
+	}
+	sym, ok := parseSymbol(v[:i])
+	if !ok {
+		return fmt.Errorf("unknown marker %q", v[:i])
+	}
+	payload, err := ioutil.ReadFile(v[i+1:])
+	if err != nil {
+		return err
+	}
+	x, err := newAddition(sym, payload)
+	if err != nil {
+		return err
+	}
+	*a = append(*a, x)
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrBadTIFF = errors.New("malformed TIFF structure")
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// tiff gives access to the IFDs of a TIFF structure, as embedded in an
+// EXIF APP1 segment.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag    uint16
+	typ    uint16
+	count  int
+	offset int // offset of the value in the TIFF data
+}
+
+// typeSizes maps TIFF field types to their size in bytes.
+var typeSizes = [...]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+func newTIFF(b []byte) (*tiff, error) {
+	if len(b) < 8 {
+		return nil, ErrBadTIFF
+	}
+	t := &tiff{data: b}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, ErrBadTIFF
+	}
+	if t.order.Uint16(b[2:]) != 42 {
+		return nil, ErrBadTIFF
+	}
+	return t, nil
+}
+
+func (t *tiff) first() int {
+	return int(t.order.Uint32(t.data[4:]))
+}
+
+// ifd reads the IFD at off and returns its entries and the offset of the
+// next IFD. Entries whose value lies outside the data are dropped.
+func (t *tiff) ifd(off int) ([]ifdEntry, int, error) {
+	if off < 8 || off+2 > len(t.data) {
+		return nil, 0, ErrBadTIFF
+	}
+	n := int(t.order.Uint16(t.data[off:]))
+	end := off + 2 + 12*n
+	if end > len(t.data) {
+		return nil, 0, ErrBadTIFF
+	}
+	var entries []ifdEntry
+	for i := 0; i < n; i++ {
+		p := t.data[off+2+12*i:]
+		e := ifdEntry{
+			tag:    t.order.Uint16(p),
+			typ:    t.order.Uint16(p[2:]),
+			count:  int(t.order.Uint32(p[4:])),
+			offset: off + 2 + 12*i + 8,
+		}
+		size := e.size()
+		if size < 0 {
+			continue
+		}
+		if size > 4 {
+			e.offset = int(t.order.Uint32(p[8:]))
+		}
+		if e.offset < 0 || e.offset+size > len(t.data) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	next := 0
+	if end+4 <= len(t.data) {
+		next = int(t.order.Uint32(t.data[end:]))
+	}
+	return entries, next, nil
+}
+
+// size returns the size of the entry value, or -1 if it is unknown.
+func (e ifdEntry) size() int {
+	if int(e.typ) >= len(typeSizes) || e.typ == 0 || e.count < 0 || e.count > 1<<24 {
+		return -1
+	}
+	return typeSizes[e.typ] * e.count
+}
+
+func (t *tiff) value(e ifdEntry) []byte {
+	return t.data[e.offset : e.offset+e.size()]
+}
+
+// uint returns the i-th value of a BYTE, SHORT or LONG entry.
+func (t *tiff) uint(e ifdEntry, i int) (uint32, bool) {
+	if i >= e.count {
+		return 0, false
+	}
+	v := t.value(e)
+	switch e.typ {
+	case 1, 7:
+		return uint32(v[i]), true
+	case 3:
+		return uint32(t.order.Uint16(v[2*i:])), true
+	case 4:
+		return t.order.Uint32(v[4*i:]), true
+	}
+	return 0, false
+}
+
+// rational returns the i-th value of a RATIONAL entry.
+func (t *tiff) rational(e ifdEntry, i int) (num, den uint32, ok bool) {
+	if e.typ != 5 || i >= e.count {
+		return 0, 0, false
+	}
+	v := t.value(e)[8*i:]
+	return t.order.Uint32(v), t.order.Uint32(v[4:]), true
+}
+
+func (t *tiff) ascii(e ifdEntry) string {
+	return string(bytes.TrimRight(t.value(e), "\x00"))
+}
+
+func lookup(entries []ifdEntry, tag uint16) (ifdEntry, bool) {
+	for _, e := range entries {
+		if e.tag == tag {
+			return e, true
+		}
+	}
+	return ifdEntry{}, false
+}
+
+const (
+	tagExifIFD        = 0x8769
+	tagGPSIFD         = 0x8825
+	tagThumbnailStart = 0x0201
+	tagThumbnailLen   = 0x0202
+)
+
+// exif holds the IFDs of an EXIF APP1 segment.
+type exif struct {
+	*tiff
+	ifd0 []ifdEntry
+	ifd1 []ifdEntry
+	sub  []ifdEntry
+	gps  []ifdEntry
+}
+
+func isExif(p []byte) bool {
+	return bytes.HasPrefix(p, exifHeader)
+}
+
+func parseExif(p []byte) (*exif, error) {
+	if !isExif(p) {
+		return nil, ErrBadTIFF
+	}
+	t, err := newTIFF(p[len(exifHeader):])
+	if err != nil {
+		return nil, err
+	}
+	x := &exif{tiff: t}
+	var next int
+	x.ifd0, next, err = t.ifd(t.first())
+	if err != nil {
+		return nil, err
+	}
+	if next != 0 {
+		x.ifd1, _, _ = t.ifd(next)
+	}
+	if e, ok := lookup(x.ifd0, tagExifIFD); ok {
+		if off, ok := t.uint(e, 0); ok {
+			x.sub, _, _ = t.ifd(int(off))
+		}
+	}
+	if e, ok := lookup(x.ifd0, tagGPSIFD); ok {
+		if off, ok := t.uint(e, 0); ok {
+			x.gps, _, _ = t.ifd(int(off))
+		}
+	}
+	return x, nil
+}
+
+// thumbnail returns the JPEG thumbnail referenced by IFD1, if any.
+func (x *exif) thumbnail() []byte {
+	s, ok1 := lookup(x.ifd1, tagThumbnailStart)
+	l, ok2 := lookup(x.ifd1, tagThumbnailLen)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	start, ok1 := x.uint(s, 0)
+	n, ok2 := x.uint(l, 0)
+	if !ok1 || !ok2 || int(start)+int(n) > len(x.data) {
+		return nil
+	}
+	return x.data[int(start) : int(start)+int(n)]
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+)
+
+var ErrBadExtract = errors.New("extract spec must be MARKER[:index]=path")
+
+// extractSpec selects the index-th segment (counting from 1) with marker
+// sym, to be written to path.
+type extractSpec struct {
+	sym   symbol
+	index int
+	path  string
+}
+
+// extractSpecs implements flag.Value for repeated -extract flags.
+type extractSpecs []extractSpec
+
+func (e *extractSpecs) String() string {
+	var s []string
+	for _, x := range *e {
+		s = append(s, fmt.Sprintf("%s:%d=%s", x.sym.Short(), x.index, x.path))
+	}
+	return strings.Join(s, " ")
+}
+
+func (e *extractSpecs) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i <= 0 || i == len(v)-1 {
+		return ErrBadExtract
+	}
+	spec := extractSpec{index: 1, path: v[i+1:]}
+	name := v[:i]
+	if j := strings.IndexAny(name, ":,"); j >= 0 {
+		n, err := strconv.Atoi(name[j+1:])
+		if err != nil || n < 1 {
+			return ErrBadExtract
+		}
+		spec.index = n
+		name = name[:j]
+	}
+	sym, ok := parseSymbol(name)
+	if !ok {
+		return fmt.Errorf("unknown marker %q", name)
+	}
+	spec.sym = sym
+	*e = append(*e, spec)
+	return nil
+}
+
+// extractor writes the payloads of the segments selected by specs.
+type extractor struct {
+	w      io.Writer
+	file   string
+	specs  extractSpecs
+	counts map[symbol]int
+	done   []bool
+}
+
+func newExtractor(file string, specs extractSpecs, w io.Writer) *extractor {
+	return &extractor{
+		w:      w,
+		file:   file,
+		specs:  specs,
+		counts: make(map[symbol]int),
+		done:   make([]bool, len(specs)),
+	}
+}
+
+func (x *extractor) handle(m marker, p []byte) {
+	x.counts[m.sym]++
+	for i, s := range x.specs {
+		if s.sym != m.sym || s.index != x.counts[m.sym] {
+			continue
+		}
+		x.done[i] = true
+		if err := ioutil.WriteFile(s.path, p, 0666); err != nil {
+			log.Println(err)
+			continue
+		}
+		fmt.Fprintf(x.w, "%s: wrote %s segment #%d (%d bytes) to %s\n", displayName(x.file), s.sym.Short(), s.index, len(p), s.path)
+	}
+}
+
+// missing logs the specs that matched no segment.
+func (x *extractor) missing() {
+	for i, s := range x.specs {
+		if !x.done[i] {
+			log.Printf("%s: no %s segment #%d", displayName(x.file), s.sym.Short(), s.index)
+		}
+	}
+}
+
+// symbolSet implements flag.Value for comma-separated marker lists.
+type symbolSet map[symbol]bool
+
+func (s symbolSet) String() string {
+	var names []string
+	for sym := range s {
+		names = append(names, sym.Short())
+	}
+	return strings.Join(names, ",")
+}
+
+func (s symbolSet) Set(v string) error {
+	for _, name := range strings.Split(v, ",") {
+		sym, ok := parseSymbol(strings.TrimSpace(name))
+		if !ok {
+			return fmt.Errorf("unknown marker %q", name)
+		}
+		s[sym] = true
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// stdinName is the file name standing for the standard input.
+const stdinName = "-"
+
+// openInput opens file for reading, stdinName meaning the standard input
+// and http(s) URLs being fetched lazily.
+func openInput(file string) (io.ReadCloser, error) {
+	if file == stdinName {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	if isURL(file) {
+		return newHTTPReader(file)
+	}
+	return os.Open(file)
+}
+
+// displayName returns the name file is reported under in the output.
+func displayName(file string) string {
+	if file == stdinName {
+		return "<stdin>"
+	}
+	return file
+}
+
+// baseName returns the prefix of the names of the files written from file.
+func baseName(file string) string {
+	if file == stdinName {
+		return "stdin"
+	}
+	if isURL(file) {
+		return urlBase(file)
+	}
+	return filepath.Base(file)
+}
+
+// expandArgs turns the command line arguments into a list of files,
+// expanding glob patterns (including ** for any number of directories)
+// and, if recursive is set, walking directories for files whose extension
+// is in exts.
+func expandArgs(args []string, recursive bool, exts []string) []string {
+	var files []string
+	for _, arg := range args {
+		if arg == stdinName || isURL(arg) {
+			files = append(files, arg)
+			continue
+		}
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err := glob(arg)
+			if err != nil {
+				log.Println(arg, err)
+			}
+			files = append(files, matches...)
+			continue
+		}
+		fi, err := os.Stat(arg)
+		if err != nil || !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		if !recursive {
+			log.Printf("%s: is a directory, use -r to scan it", arg)
+			continue
+		}
+		filepath.Walk(arg, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				log.Println(err)
+				return nil
+			}
+			if fi.Mode().IsRegular() && hasExt(path, exts) {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+func hasExt(path string, exts []string) bool {
+	ext := filepath.Ext(path)
+	for _, e := range exts {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseExts splits a comma-separated list of extensions, adding the
+// leading dot if missing.
+func parseExts(list string) []string {
+	var exts []string
+	for _, e := range strings.Split(list, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		exts = append(exts, e)
+	}
+	return exts
+}
+
+// glob expands pattern like filepath.Glob, with ** also matching any
+// number of directories.
+func glob(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+	root := pattern[:strings.Index(pattern, "**")]
+	if i := strings.LastIndexAny(root, `/\`); i >= 0 {
+		root = root[:i+1]
+	} else {
+		root = "."
+	}
+	re, err := globRegexp(filepath.Clean(pattern))
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() && re.MatchString(filepath.Clean(path)) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(pattern[i:], ']')
+			if j < 0 {
+				return nil, filepath.ErrBadPattern
+			}
+			class := pattern[i+1 : i+j]
+			if strings.HasPrefix(class, "^") {
+				class = "\\" + class
+			}
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+const (
+	tagMake     = 0x010f
+	tagModel    = 0x0110
+	tagSoftware = 0x0131
+)
+
+// Coefficient histograms are kept for the first AC coefficients of the
+// first component, over values -histRange..histRange.
+const (
+	histCoefs = 5
+	histRange = 16
+)
+
+// A histogram takes part in the verdict only if it has enough non-zero
+// coefficients and enough bins populated to be judged: small or strongly
+// quantized images leave a few sparse bins whose noise looks periodic.
+const (
+	minHistCoefs  = 1000
+	minHistJudged = 4
+)
+
+// histogram counts the values of a quantized DCT coefficient, folded so
+// that h[v] counts both v and -v. The last bin also counts the values
+// past histRange.
+type histogram [histRange + 1]int
+
+// nonzero returns the number of non-zero coefficients counted.
+func (h *histogram) nonzero() int {
+	n := 0
+	for _, c := range h[1:] {
+		n += c
+	}
+	return n
+}
+
+// anomalies counts the bins of h that are much higher or lower than both
+// of their neighbours, which a smooth single-compression histogram does
+// not have, and the number of bins populated enough to be judged.
+func (h *histogram) anomalies() (n, judged int) {
+	// The last bin is not a neighbour, as it accumulates larger values.
+	for v := 2; v < histRange-1; v++ {
+		a, b := float64(h[v-1]), float64(h[v+1])
+		if a+b < 20 {
+			continue
+		}
+		judged++
+		mean := math.Sqrt(math.Max(a, 1) * math.Max(b, 1))
+		if x := float64(h[v]); x < mean/2 || x > 2*mean {
+			n++
+		}
+	}
+	return n, judged
+}
+
+// printForensic prints the indications that file was re-saved: camera
+// metadata next to software quantization tables, editing software, and
+// periodic artifacts in the coefficient histograms of sequential images
+// left by quantizing twice with different tables.
+func printForensic(file string, r Reader, w io.Writer) error {
+	name := displayName(file)
+	ps := newParser(ioutil.Discard)
+	ps.single = true
+	var camera, model, software string
+	ps.handlers = append(ps.handlers, func(m marker, p []byte) {
+		if m.sym != APP1 || !isExif(p) || camera != "" {
+			return
+		}
+		x, err := parseExif(p)
+		if err != nil {
+			return
+		}
+		for _, f := range []struct {
+			tag uint16
+			s   *string
+		}{{tagMake, &camera}, {tagModel, &model}, {tagSoftware, &software}} {
+			if e, ok := lookup(x.ifd0, f.tag); ok && e.typ == 2 {
+				*f.s = strings.TrimSpace(x.ascii(e))
+			}
+		}
+	})
+	var hist [histCoefs]histogram
+	var first int
+	v := newScanVerifier(ps)
+	v.coef = func(id, k, c int) {
+		if id != first || k > histCoefs {
+			return
+		}
+		if c < 0 {
+			c = -c
+		}
+		if c > histRange {
+			c = histRange
+		}
+		hist[k-1][c]++
+	}
+	ps.observers = append(ps.observers, func(m marker, p []byte) {
+		if m.sym.isSOF() && m.frame != nil && len(m.frame.components) > 0 {
+			first = m.frame.components[0].id
+		}
+		v.observe(m, p)
+	})
+	err := ps.parse(r)
+	v.flush()
+	ps.finish()
+	if ps.fr == nil {
+		fmt.Fprintf(w, "%s: no frame\n", name)
+		return ps.result(err)
+	}
+
+	var reasons []string
+	insufficient := false
+	std := true
+	for _, t := range ps.qt {
+		if t == nil {
+			continue
+		}
+		q, ok := t.standard()
+		if ok {
+			fmt.Fprintf(w, "%s: table %d: libjpeg standard, quality %d\n", name, t.id, q)
+		} else {
+			fmt.Fprintf(w, "%s: table %d: custom, closest libjpeg quality %d\n", name, t.id, q)
+			std = false
+		}
+	}
+	if camera != "" || model != "" || software != "" {
+		fmt.Fprintf(w, "%s: exif: make %q model %q software %q\n", name, camera, model, software)
+	}
+	if camera != "" && std {
+		reasons = append(reasons, "camera metadata with standard libjpeg tables")
+	}
+	if software != "" && camera != "" {
+		reasons = append(reasons, "processed by "+software)
+	}
+
+	if ps.fr.sym&3 == 2 || ps.fr.sym.arithmetic() || ps.fr.sym.lossless() {
+		fmt.Fprintf(w, "%s: histograms: not available for %s\n", name, ps.fr.sym.process())
+	} else {
+		periodic, usable := 0, 0
+		for k := range hist {
+			n, judged := hist[k].anomalies()
+			if c := hist[k].nonzero(); c < minHistCoefs || judged < minHistJudged {
+				fmt.Fprintf(w, "%s: histogram %d: insufficient data (%d non-zero coefficients, %d bins judged)\n", name, k+1, c, judged)
+				continue
+			}
+			usable++
+			fmt.Fprintf(w, "%s: histogram %d: %d anomalous bins of %d\n", name, k+1, n, judged)
+			if n >= 2 && 4*n >= judged {
+				periodic++
+			}
+		}
+		if periodic >= 2 {
+			reasons = append(reasons, fmt.Sprintf("%d of %d coefficient histograms show double quantization artifacts", periodic, usable))
+		}
+		insufficient = usable < 2
+	}
+
+	switch {
+	case len(reasons) == 0 && insufficient:
+		fmt.Fprintf(w, "%s: verdict: insufficient data\n", name)
+	case len(reasons) == 0:
+		fmt.Fprintf(w, "%s: verdict: no indication of re-saving\n", name)
+	default:
+		fmt.Fprintf(w, "%s: verdict: likely re-saved: %s\n", name, strings.Join(reasons, "; "))
+	}
+	return ps.result(err)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// encodePhoto returns a JPEG of a w x h image with smooth shapes and
+// noise, closer to the statistics of a photograph than a gradient.
+func encodePhoto(t *testing.T, w, h, quality int) []byte {
+	t.Helper()
+	r := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 128 + 60*math.Sin(float64(x)/17)*math.Cos(float64(y)/23) + r.NormFloat64()*12
+			g := 128 + 50*math.Sin(float64(x+y)/31) + r.NormFloat64()*8
+			img.Set(x, y, color.RGBA{clampByte(v), clampByte(g), clampByte((v + g) / 2), 255})
+		}
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func clampByte(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, v)))
+}
+
+func TestForensicSingleCompression(t *testing.T) {
+	small := encodeGray(t, 64, 48)
+	malformed := encodeGray(t, 64, 48)
+	sos := sosHeader(t, malformed)
+	malformed[sos+2], malformed[sos+4] = 0x0f, 0
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"64x48", small},
+		{"64x48 q75", encodePhoto(t, 64, 48, 75)},
+		{"640x480 q50", encodePhoto(t, 640, 480, 50)},
+		{"1024x768 q85", encodePhoto(t, 1024, 768, 85)},
+		{"sequential se=0 ta=15", malformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			printForensic("test.jpg", bufio.NewReader(bytes.NewReader(tt.data)), &out)
+			if strings.Contains(out.String(), "likely re-saved") {
+				t.Errorf("single-compressed image reported as re-saved:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestForensicDoubleCompression(t *testing.T) {
+	img, err := jpeg.Decode(bytes.NewReader(encodePhoto(t, 640, 480, 70)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	printForensic("test.jpg", bufio.NewReader(&b), &out)
+	if !strings.Contains(out.String(), "likely re-saved") {
+		t.Errorf("image saved at quality 70 then 90 not reported as re-saved:\n%s", out.String())
+	}
+}
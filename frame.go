@@ -0,0 +1,150 @@
+package main
+
+import "errors"
+
+var ErrShortSegment = errors.New("segment too short")
+
+type component struct {
+	id int
+	h  int
+	v  int
+	tq int
+}
+
+// frame holds the parameters of a frame header (SOFn).
+type frame struct {
+	sym        symbol
+	precision  int
+	height     int // 0 if set by a DNL segment after the first scan
+	width      int
+	components []component
+	dnl        int // number of lines set by DNL, if any
+}
+
+func parseSOF(sym symbol, p []byte) (*frame, error) {
+	if len(p) < 6 {
+		return nil, ErrShortSegment
+	}
+	f := &frame{
+		sym:       sym,
+		precision: int(p[0]),
+		height:    int(p[1])<<8 + int(p[2]),
+		width:     int(p[3])<<8 + int(p[4]),
+	}
+	n := int(p[5])
+	if len(p) < 6+3*n {
+		return nil, ErrShortSegment
+	}
+	for i := 0; i < n; i++ {
+		c := p[6+3*i:]
+		f.components = append(f.components, component{
+			id: int(c[0]),
+			h:  int(c[1] >> 4),
+			v:  int(c[1] & 0xf),
+			tq: int(c[2]),
+		})
+	}
+	return f, nil
+}
+
+// lines returns the height of the image, as declared by the frame header
+// or else set by DNL, or 0 if it is not known yet.
+func (f *frame) lines() int {
+	if f.height == 0 {
+		return f.dnl
+	}
+	return f.height
+}
+
+func (f *frame) maxSampling() (hmax, vmax int) {
+	for _, c := range f.components {
+		if c.h > hmax {
+			hmax = c.h
+		}
+		if c.v > vmax {
+			vmax = c.v
+		}
+	}
+	return hmax, vmax
+}
+
+func (f *frame) component(id int) *component {
+	for i := range f.components {
+		if f.components[i].id == id {
+			return &f.components[i]
+		}
+	}
+	return nil
+}
+
+// mcus returns the number of MCUs in a scan of the given components, or 0
+// if it cannot be computed.
+func (f *frame) mcus(ids []int) int {
+	hmax, vmax := f.maxSampling()
+	height := f.lines()
+	if hmax == 0 || vmax == 0 || f.width == 0 || height == 0 {
+		return 0
+	}
+	if len(ids) == 1 {
+		// Non-interleaved scans have one data unit per MCU.
+		c := f.component(ids[0])
+		if c == nil {
+			return 0
+		}
+		w := ceilDiv(ceilDiv(f.width*c.h, hmax), 8)
+		h := ceilDiv(ceilDiv(height*c.v, vmax), 8)
+		return w * h
+	}
+	return ceilDiv(f.width, 8*hmax) * ceilDiv(height, 8*vmax)
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+type scanComponent struct {
+	id int
+	td int
+	ta int
+}
+
+// scanHeader holds the parameters of a scan header (SOS).
+type scanHeader struct {
+	components []scanComponent
+	ss         int
+	se         int
+	ah         int
+	al         int
+}
+
+func parseSOS(p []byte) (*scanHeader, error) {
+	if len(p) < 1 {
+		return nil, ErrShortSegment
+	}
+	n := int(p[0])
+	if len(p) < 4+2*n {
+		return nil, ErrShortSegment
+	}
+	sh := &scanHeader{
+		ss: int(p[1+2*n]),
+		se: int(p[2+2*n]),
+		ah: int(p[3+2*n] >> 4),
+		al: int(p[3+2*n] & 0xf),
+	}
+	for i := 0; i < n; i++ {
+		sh.components = append(sh.components, scanComponent{
+			id: int(p[1+2*i]),
+			td: int(p[2+2*i] >> 4),
+			ta: int(p[2+2*i] & 0xf),
+		})
+	}
+	return sh, nil
+}
+
+func (sh *scanHeader) ids() []int {
+	ids := make([]int, len(sh.components))
+	for i, c := range sh.components {
+		ids[i] = c.id
+	}
+	return ids
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// location is a position read from the GPS IFD of EXIF data.
+type location struct {
+	lat, lon float64
+	alt      float64
+	hasAlt   bool
+}
+
+// location returns the position recorded in the GPS IFD, if any.
+func (x *exif) location() (*location, bool) {
+	lat, ok1 := x.degrees(tagGPSLatitude, tagGPSLatitudeRef, "S")
+	lon, ok2 := x.degrees(tagGPSLongitude, tagGPSLongitudeRef, "W")
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	loc := &location{lat: lat, lon: lon}
+	if e, ok := lookup(x.gps, tagGPSAltitude); ok {
+		if num, den, ok := x.rational(e, 0); ok && den != 0 {
+			loc.alt, loc.hasAlt = float64(num)/float64(den), true
+			if e, ok := lookup(x.gps, tagGPSAltitudeRef); ok {
+				if v, ok := x.uint(e, 0); ok && v == 1 {
+					loc.alt = -loc.alt
+				}
+			}
+		}
+	}
+	return loc, true
+}
+
+// degrees converts a degrees, minutes, seconds triplet to decimal degrees,
+// negated if the reference tag is neg.
+func (x *exif) degrees(tag, ref uint16, neg string) (float64, bool) {
+	e, ok := lookup(x.gps, tag)
+	if !ok || e.count < 3 {
+		return 0, false
+	}
+	v := 0.0
+	for i, unit := range []float64{1, 60, 3600} {
+		num, den, ok := x.rational(e, i)
+		if !ok {
+			return 0, false
+		}
+		if den == 0 {
+			if num != 0 {
+				return 0, false
+			}
+			continue
+		}
+		v += float64(num) / float64(den) / unit
+	}
+	if e, ok := lookup(x.gps, ref); ok && x.ascii(e) == neg {
+		v = -v
+	}
+	return v, true
+}
+
+// printGPS prints the position recorded in the EXIF data of file, and a
+// map URL if url is set.
+func printGPS(file string, r Reader, url bool, w io.Writer) error {
+	ps := newParser(ioutil.Discard)
+	ps.single = true
+	ps.headers = true
+	// Only EXIF is needed: other payloads are skipped, by seeking in
+	// regular files.
+	ps.want = func(s symbol) bool { return s == APP1 }
+	var loc *location
+	ps.handlers = append(ps.handlers, func(m marker, p []byte) {
+		if m.sym != APP1 || !isExif(p) || loc != nil {
+			return
+		}
+		if x, err := parseExif(p); err == nil {
+			loc, _ = x.location()
+		}
+	})
+	err := ps.parse(r)
+	ps.finish()
+	name := displayName(file)
+	if loc == nil {
+		fmt.Fprintf(w, "%s: no GPS position\n", name)
+		return ps.result(err)
+	}
+	fmt.Fprintf(w, "%s: GPS %.6f,%.6f", name, loc.lat, loc.lon)
+	if loc.hasAlt {
+		fmt.Fprintf(w, " altitude=%.1fm", loc.alt)
+	}
+	if url {
+		fmt.Fprintf(w, " https://www.openstreetmap.org/?mlat=%.6f&mlon=%.6f#map=16/%.6f/%.6f", loc.lat, loc.lon, loc.lat, loc.lon)
+	}
+	fmt.Fprintln(w)
+	return ps.result(err)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// parseHash returns the hash function named by the -hash flag, or nil if
+// it is empty.
+func parseHash(name string) (func() hash.Hash, error) {
+	if name == "" {
+		return nil, nil
+	}
+	h, ok := hashes[name]
+	if !ok {
+		var names []string
+		for n := range hashes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown hash %q, want one of %s", name, strings.Join(names, ", "))
+	}
+	return h, nil
+}
+
+// printHashes prints a digest of the payload of each segment of file,
+// then for each image a digest of its entropy-coded data alone, which
+// does not change when only the metadata does.
+func printHashes(file string, r Reader, name string, newHash func() hash.Hash, w io.Writer) error {
+	label := displayName(file)
+	ps := newParser(ioutil.Discard)
+	var images []*digest
+	ps.handlers = append(ps.handlers, func(m marker, p []byte) {
+		if !m.sym.hasLength() {
+			return
+		}
+		h := newHash()
+		h.Write(p)
+		fmt.Fprintf(w, "%s: %s\toffset=%d\tsize=%d\t%s=%x\n", label, m.sym.Short(), m.offset-2, m.size, name, h.Sum(nil))
+		if m.sym != SOS {
+			return
+		}
+		for len(images) < m.image {
+			images = append(images, newDigest(newHash()))
+		}
+		ps.scanData = images[m.image-1]
+		ps.pending = 0
+	})
+	err := ps.parse(r)
+	ps.finish()
+	for i, d := range images {
+		fmt.Fprintf(w, "%s: image %d\tdata=%d\t%s=%x\n", label, i+1, d.n, name, d.Sum(nil))
+	}
+	return ps.result(err)
+}
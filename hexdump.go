@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const defaultHexdump = 64
+
+// hexdumpFlag is the number of payload bytes to dump. It implements
+// flag.Value and may be given without a value.
+type hexdumpFlag int
+
+func (h *hexdumpFlag) IsBoolFlag() bool { return true }
+
+func (h *hexdumpFlag) String() string {
+	return strconv.Itoa(int(*h))
+}
+
+func (h *hexdumpFlag) Set(v string) error {
+	switch v {
+	case "true":
+		*h = defaultHexdump
+		return nil
+	case "false":
+		*h = 0
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return errors.New("hexdump takes a byte count")
+	}
+	*h = hexdumpFlag(n)
+	return nil
+}
+
+// hexdump writes p in hex and ASCII, indented under the marker line.
+func hexdump(w io.Writer, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	d := strings.TrimSuffix(hex.Dump(p), "\n")
+	io.WriteString(w, "  "+strings.Replace(d, "\n", "\n  ", -1)+"\n")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+var ErrBadICC = errors.New("malformed ICC profile")
+
+// iccProfile holds the header fields of an ICC profile and its
+// description.
+type iccProfile struct {
+	size    int
+	version string
+	class   string
+	space   string
+	pcs     string
+	intent  int
+	date    string
+	desc    string
+}
+
+var iccIntents = []string{"perceptual", "relative colorimetric", "saturation", "absolute colorimetric"}
+
+// addICC collects the chunks of an ICC profile split across APP2
+// segments, and dumps the profile once all of them have been read.
+func (ps *parser) addICC(offset int, p []byte) {
+	p = p[len(iccHeader):]
+	if len(p) < 2 || p[0] == 0 || p[0] > p[1] {
+		ps.problemf(diagBadMetadata, offset, "ICC: invalid chunk number")
+		return
+	}
+	seq, n := int(p[0]), int(p[1])
+	if len(ps.icc) != n {
+		ps.icc = make([][]byte, n)
+	}
+	ps.icc[seq-1] = append([]byte(nil), p[2:]...)
+	for _, c := range ps.icc {
+		if c == nil {
+			return
+		}
+	}
+	prof, err := parseICC(bytes.Join(ps.icc, nil))
+	ps.icc = nil
+	if err != nil {
+		ps.problemf(diagBadMetadata, offset, "ICC: %v", err)
+		return
+	}
+	ps.dumpICC(prof)
+}
+
+func parseICC(b []byte) (*iccProfile, error) {
+	if len(b) < 132 || string(b[36:40]) != "acsp" {
+		return nil, ErrBadICC
+	}
+	be := binary.BigEndian
+	prof := &iccProfile{
+		size:    int(be.Uint32(b)),
+		version: fmt.Sprintf("%d.%d.%d", b[8], b[9]>>4, b[9]&0xf),
+		class:   string(bytes.TrimRight(b[12:16], " ")),
+		space:   string(bytes.TrimRight(b[16:20], " ")),
+		pcs:     string(bytes.TrimRight(b[20:24], " ")),
+		intent:  int(be.Uint32(b[64:])),
+	}
+	var d [6]int
+	for i := range d {
+		d[i] = int(be.Uint16(b[24+2*i:]))
+	}
+	prof.date = fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", d[0], d[1], d[2], d[3], d[4], d[5])
+
+	n := int(be.Uint32(b[128:]))
+	for i := 0; i < n && 132+12*i+12 <= len(b); i++ {
+		e := b[132+12*i:]
+		if string(e[:4]) != "desc" {
+			continue
+		}
+		off, size := int(be.Uint32(e[4:])), int(be.Uint32(e[8:]))
+		if off < 0 || size < 0 || off+size > len(b) || off+size < off {
+			return prof, ErrBadICC
+		}
+		prof.desc = iccText(b[off : off+size])
+	}
+	return prof, nil
+}
+
+// iccText decodes a textDescriptionType (ICC v2) or the first record of a
+// multiLocalizedUnicodeType (ICC v4) tag.
+func iccText(t []byte) string {
+	be := binary.BigEndian
+	switch {
+	case len(t) >= 12 && string(t[:4]) == "desc":
+		n := int(be.Uint32(t[8:]))
+		if n > len(t)-12 {
+			n = len(t) - 12
+		}
+		return string(bytes.TrimRight(t[12:12+n], "\x00"))
+	case len(t) >= 28 && string(t[:4]) == "mluc":
+		n, off := int(be.Uint32(t[20:])), int(be.Uint32(t[24:]))
+		if off < 0 || n < 0 || off+n > len(t) || off+n < off {
+			return ""
+		}
+		s := t[off : off+n]
+		u := make([]uint16, len(s)/2)
+		for i := range u {
+			u[i] = be.Uint16(s[2*i:])
+		}
+		return string(utf16.Decode(u))
+	}
+	return ""
+}
+
+func (ps *parser) dumpICC(prof *iccProfile) {
+	intent := fmt.Sprint(prof.intent)
+	if prof.intent >= 0 && prof.intent < len(iccIntents) {
+		intent = iccIntents[prof.intent]
+	}
+	fmt.Fprintf(ps.w, "ICC\tsize=%d\tversion=%s\tclass=%s\tspace=%s\tpcs=%s\tintent=%s\tdate=%s",
+		prof.size, prof.version, prof.class, prof.space, prof.pcs, intent, prof.date)
+	if prof.desc != "" {
+		fmt.Fprintf(ps.w, "\tdesc=%q", prof.desc)
+	}
+	fmt.Fprintf(ps.w, "\n")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrBadPhotoshop = errors.New("malformed Photoshop resource block")
+
+const resIPTC = 0x0404
+
+// psResource is an image resource block ("8BIM") of a Photoshop APP13
+// segment.
+type psResource struct {
+	id   int
+	name string
+	data []byte
+}
+
+var psResourceNames = map[int]string{
+	0x03ed: "resolution info",
+	0x0404: "IPTC-NAA",
+	0x0406: "JPEG quality",
+	0x0408: "grid and guides",
+	0x0409: "thumbnail (Photoshop 4)",
+	0x040a: "copyright flag",
+	0x040b: "URL",
+	0x040c: "thumbnail",
+	0x040f: "ICC profile",
+	0x0414: "document ID seed",
+	0x041a: "slices",
+	0x041e: "URL list",
+	0x0421: "version info",
+	0x0422: "EXIF data 1",
+	0x0424: "XMP metadata",
+	0x0425: "IPTC digest",
+	0x0426: "print scale",
+	0x0428: "pixel aspect ratio",
+	0x043a: "print information",
+	0x043b: "print style",
+}
+
+// parsePhotoshop splits the payload of a Photoshop APP13 segment into its
+// resource blocks.
+func parsePhotoshop(p []byte) ([]psResource, error) {
+	if !bytes.HasPrefix(p, psHeader) {
+		return nil, ErrBadPhotoshop
+	}
+	p = p[len(psHeader):]
+	var res []psResource
+	for len(p) > 0 {
+		if len(p) < 7 || string(p[:4]) != "8BIM" {
+			return res, ErrBadPhotoshop
+		}
+		r := psResource{id: int(binary.BigEndian.Uint16(p[4:]))}
+		// The name is a Pascal string padded to an even length.
+		n := int(p[6])
+		i := 7 + n + (n+1)%2
+		if len(p) < i+4 {
+			return res, ErrBadPhotoshop
+		}
+		r.name = string(p[7 : 7+n])
+		size := int(binary.BigEndian.Uint32(p[i:]))
+		i += 4
+		if size < 0 || size > len(p)-i {
+			return res, ErrBadPhotoshop
+		}
+		r.data = p[i : i+size]
+		res = append(res, r)
+		i += size + size%2
+		if i > len(p) {
+			i = len(p)
+		}
+		p = p[i:]
+	}
+	return res, nil
+}
+
+// iptcDataset is a dataset of an IPTC-IIM record.
+type iptcDataset struct {
+	record  int
+	dataset int
+	data    []byte
+}
+
+var iptcNames = map[int]string{
+	0x0100 | 90:  "CodedCharacterSet",
+	0x0200 | 0:   "RecordVersion",
+	0x0200 | 5:   "ObjectName",
+	0x0200 | 10:  "Urgency",
+	0x0200 | 15:  "Category",
+	0x0200 | 20:  "SupplementalCategory",
+	0x0200 | 25:  "Keywords",
+	0x0200 | 40:  "SpecialInstructions",
+	0x0200 | 55:  "DateCreated",
+	0x0200 | 60:  "TimeCreated",
+	0x0200 | 80:  "By-line",
+	0x0200 | 85:  "By-lineTitle",
+	0x0200 | 90:  "City",
+	0x0200 | 92:  "Sublocation",
+	0x0200 | 95:  "Province-State",
+	0x0200 | 100: "CountryCode",
+	0x0200 | 101: "Country",
+	0x0200 | 103: "OriginalTransmissionReference",
+	0x0200 | 105: "Headline",
+	0x0200 | 110: "Credit",
+	0x0200 | 115: "Source",
+	0x0200 | 116: "CopyrightNotice",
+	0x0200 | 118: "Contact",
+	0x0200 | 120: "Caption-Abstract",
+	0x0200 | 122: "Writer-Editor",
+}
+
+// parseIPTC decodes the datasets of IPTC-IIM data, as found in resource
+// 0x0404.
+func parseIPTC(p []byte) ([]iptcDataset, error) {
+	var ds []iptcDataset
+	for len(p) > 0 {
+		if p[0] != 0x1c {
+			// Resources are often padded with zeros.
+			if len(bytes.Trim(p, "\x00")) == 0 {
+				break
+			}
+			return ds, ErrBadPhotoshop
+		}
+		if len(p) < 5 {
+			return ds, ErrBadPhotoshop
+		}
+		d := iptcDataset{record: int(p[1]), dataset: int(p[2])}
+		n := int(binary.BigEndian.Uint16(p[3:]))
+		i := 5
+		if n&0x8000 != 0 {
+			// Extended dataset: the low bits give the size of the length.
+			l := n & 0x7fff
+			if l > 4 || len(p) < i+l {
+				return ds, ErrBadPhotoshop
+			}
+			n = 0
+			for _, b := range p[i : i+l] {
+				n = n<<8 | int(b)
+			}
+			i += l
+		}
+		if n > len(p)-i {
+			return ds, ErrBadPhotoshop
+		}
+		d.data = p[i : i+n]
+		ds = append(ds, d)
+		p = p[i+n:]
+	}
+	return ds, nil
+}
+
+func (ps *parser) dumpPhotoshop(offset int, res []psResource) {
+	fmt.Fprintf(ps.w, "8BIM\tresources=%d\n", len(res))
+	for _, r := range res {
+		fmt.Fprintf(ps.w, "  %#04x", r.id)
+		if name, ok := psResourceNames[r.id]; ok {
+			fmt.Fprintf(ps.w, " %s", name)
+		}
+		if r.name != "" {
+			fmt.Fprintf(ps.w, " %q", r.name)
+		}
+		fmt.Fprintf(ps.w, " size=%d\n", len(r.data))
+	}
+	for _, r := range res {
+		if r.id != resIPTC {
+			continue
+		}
+		ds, err := parseIPTC(r.data)
+		if err != nil {
+			ps.problemf(diagBadMetadata, offset, "IPTC: %v", err)
+		}
+		fmt.Fprintf(ps.w, "IPTC\tdatasets=%d\n", len(ds))
+		for _, d := range ds {
+			fmt.Fprintf(ps.w, "  %d:%d", d.record, d.dataset)
+			if name, ok := iptcNames[d.record<<8|d.dataset]; ok {
+				fmt.Fprintf(ps.w, " %s", name)
+			}
+			fmt.Fprintf(ps.w, " %q\n", d.data)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package jpegseg writes JPEG streams segment by segment, for programs
+// doing lossless structural edits: removing, replacing or inserting
+// segments without decoding the image.
+package jpegseg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrSegmentOrder = errors.New("segment out of order")
+	ErrSegmentSize  = errors.New("segment payload too large")
+	ErrNotEnded     = errors.New("stream does not end with EOI")
+)
+
+// Markers the Builder checks the order of.
+const (
+	SOI   = 0xd8
+	EOI   = 0xd9
+	SOS   = 0xda
+	DHP   = 0xde
+	TEM   = 0x01
+	RST0  = 0xd0
+	RST7  = 0xd7
+	DHT   = 0xc4
+	JPG   = 0xc8
+	DAC   = 0xcc
+	SOF55 = 0xf7
+)
+
+// MaxPayload is the largest payload a segment can hold.
+const MaxPayload = 0xffff - 2
+
+// Segment is a marker with its payload, without the length field. For
+// SOS, Data holds the entropy-coded data following the header, stuffed
+// and including its RST markers.
+type Segment struct {
+	Marker  byte
+	Payload []byte
+	Data    []byte
+}
+
+// IsSOF reports whether m starts a frame header, of any coding process.
+func IsSOF(m byte) bool {
+	return 0xc0 <= m && m <= 0xcf && m != DHT && m != JPG && m != DAC || m == SOF55
+}
+
+// IsRST reports whether m is one of the restart markers, which are part
+// of the entropy-coded data.
+func IsRST(m byte) bool {
+	return RST0 <= m && m <= RST7
+}
+
+// HasLength reports whether segments with marker m have a length field
+// and a payload.
+func HasLength(m byte) bool {
+	return m != SOI && m != EOI && m != TEM && !IsRST(m)
+}
+
+func name(m byte) string {
+	switch {
+	case m == SOI:
+		return "SOI"
+	case m == EOI:
+		return "EOI"
+	case m == SOS:
+		return "SOS"
+	case IsRST(m):
+		return fmt.Sprintf("RST%d", m-RST0)
+	}
+	return fmt.Sprintf("marker %#02x", m)
+}
+
+// AppendSegment appends to b the segment with marker m and payload p,
+// marker and length field included.
+func AppendSegment(b []byte, m byte, p []byte) []byte {
+	b = append(b, 0xff, m)
+	if HasLength(m) {
+		n := len(p) + 2
+		b = append(b, byte(n>>8), byte(n))
+		b = append(b, p...)
+	}
+	return b
+}
+
+// Builder writes a JPEG stream from a sequence of segments, computing
+// their length fields and checking that they are in an order decoders
+// accept. Several images may follow each other, as in MPF files.
+type Builder struct {
+	w      io.Writer
+	n      int64
+	seen   int
+	frames int
+	hier   bool
+	scan   bool // the last segment was SOS
+	eoi    bool
+	err    error
+}
+
+// NewBuilder returns a Builder writing to w.
+func NewBuilder(w io.Writer) *Builder {
+	return &Builder{w: w}
+}
+
+// check returns why segment s cannot follow the segments added so far.
+func (b *Builder) check(s Segment) error {
+	m := s.Marker
+	switch {
+	case b.seen == 0 && m != SOI:
+		return fmt.Errorf("%w: %s before SOI", ErrSegmentOrder, name(m))
+	case b.seen > 0 && m == SOI && !b.eoi:
+		return fmt.Errorf("%w: second SOI", ErrSegmentOrder)
+	case b.eoi && m != SOI:
+		return fmt.Errorf("%w: %s after EOI", ErrSegmentOrder, name(m))
+	case IsRST(m):
+		return fmt.Errorf("%w: %s outside of scan data", ErrSegmentOrder, name(m))
+	case IsSOF(m) && b.frames > 0 && !b.hier:
+		return fmt.Errorf("%w: second frame header %s", ErrSegmentOrder, name(m))
+	case m == SOS && b.frames == 0:
+		return fmt.Errorf("%w: SOS before frame header", ErrSegmentOrder)
+	case !HasLength(m) && len(s.Payload) > 0:
+		return fmt.Errorf("%s has no payload", name(m))
+	case len(s.Payload) > MaxPayload:
+		return fmt.Errorf("%w: %s payload of %d bytes", ErrSegmentSize, name(m), len(s.Payload))
+	case m != SOS && len(s.Data) > 0:
+		return fmt.Errorf("%s cannot be followed by entropy-coded data", name(m))
+	}
+	return nil
+}
+
+// Add writes segment s, or returns why it cannot be written. After an
+// error, the Builder writes nothing more.
+func (b *Builder) Add(s Segment) error {
+	if b.err != nil {
+		return b.err
+	}
+	if err := b.check(s); err != nil {
+		b.err = err
+		return err
+	}
+	m := s.Marker
+	if m == SOI {
+		*b = Builder{w: b.w, n: b.n}
+	}
+	b.seen++
+	b.scan = m == SOS
+	switch {
+	case IsSOF(m):
+		b.frames++
+	case m == DHP:
+		b.hier = true
+	case m == EOI:
+		b.eoi = true
+	}
+	if err := b.write(AppendSegment(nil, m, s.Payload)); err != nil {
+		return err
+	}
+	return b.write(s.Data)
+}
+
+// Write writes p after the last segment added, which must be SOS, p then
+// being entropy-coded data, or EOI, p then being data trailing the image.
+// It is meant for data too large to be held in a Segment.
+func (b *Builder) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if !b.scan && !b.eoi {
+		b.err = fmt.Errorf("%w: data outside of a scan", ErrSegmentOrder)
+		return 0, b.err
+	}
+	if err := b.write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (b *Builder) write(p []byte) error {
+	n, err := b.w.Write(p)
+	b.n += int64(n)
+	if err != nil {
+		b.err = err
+	}
+	return err
+}
+
+// Offset returns the number of bytes written so far, which is the offset
+// in the stream of the next segment.
+func (b *Builder) Offset() int64 {
+	return b.n
+}
+
+// Close checks that the stream was ended with EOI.
+func (b *Builder) Close() error {
+	if b.err != nil {
+		return b.err
+	}
+	if !b.eoi {
+		return ErrNotEnded
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package jpegseg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBuilderOrder(t *testing.T) {
+	sof := Segment{Marker: 0xc0, Payload: []byte{8, 0, 1, 0, 1, 1, 1, 0x11, 0}}
+	sos := Segment{Marker: SOS, Payload: []byte{1, 1, 0, 0, 63, 0}, Data: []byte{0x12, 0xff, 0x00}}
+	com := Segment{Marker: 0xfe, Payload: []byte("hello")}
+	tests := []struct {
+		name     string
+		segments []Segment
+		err      error // of the last Add
+		closeErr error
+	}{
+		{"image", []Segment{{Marker: SOI}, com, sof, sos, {Marker: EOI}}, nil, nil},
+		{"two images", []Segment{{Marker: SOI}, sof, sos, {Marker: EOI}, {Marker: SOI}, sof, sos, {Marker: EOI}}, nil, nil},
+		{"no SOI", []Segment{com}, ErrSegmentOrder, ErrSegmentOrder},
+		{"second SOI", []Segment{{Marker: SOI}, {Marker: SOI}}, ErrSegmentOrder, ErrSegmentOrder},
+		{"after EOI", []Segment{{Marker: SOI}, sof, sos, {Marker: EOI}, com}, ErrSegmentOrder, ErrSegmentOrder},
+		{"second frame", []Segment{{Marker: SOI}, sof, sof}, ErrSegmentOrder, ErrSegmentOrder},
+		{"SOS before frame", []Segment{{Marker: SOI}, sos}, ErrSegmentOrder, ErrSegmentOrder},
+		{"RST", []Segment{{Marker: SOI}, sof, sos, {Marker: RST0}}, ErrSegmentOrder, ErrSegmentOrder},
+		{"payload too large", []Segment{{Marker: SOI}, {Marker: 0xfe, Payload: make([]byte, MaxPayload+1)}}, ErrSegmentSize, ErrSegmentSize},
+		{"no EOI", []Segment{{Marker: SOI}, sof, sos}, nil, ErrNotEnded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder(new(bytes.Buffer))
+			var err error
+			for _, s := range tt.segments {
+				err = b.Add(s)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Add: got %v, want %v", err, tt.err)
+			}
+			if err := b.Close(); !errors.Is(err, tt.closeErr) {
+				t.Errorf("Close: got %v, want %v", err, tt.closeErr)
+			}
+		})
+	}
+}
+
+func TestBuilderOutput(t *testing.T) {
+	var out bytes.Buffer
+	b := NewBuilder(&out)
+	b.Add(Segment{Marker: SOI})
+	b.Add(Segment{Marker: 0xfe, Payload: []byte("hi")})
+	if n := b.Offset(); n != 8 {
+		t.Errorf("got offset %d after COM, want 8", n)
+	}
+	if _, err := b.Write([]byte{1}); !errors.Is(err, ErrSegmentOrder) {
+		t.Errorf("got %v writing data outside of a scan, want ErrSegmentOrder", err)
+	}
+
+	out.Reset()
+	b = NewBuilder(&out)
+	b.Add(Segment{Marker: SOI})
+	b.Add(Segment{Marker: 0xc0, Payload: []byte{8, 0, 1, 0, 1, 1, 1, 0x11, 0}})
+	b.Add(Segment{Marker: SOS, Payload: []byte{1, 1, 0, 0, 63, 0}})
+	b.Write([]byte{0x12, 0xff, 0xd0, 0x34})
+	b.Add(Segment{Marker: EOI})
+	b.Write([]byte("trailer"))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0xff, SOI,
+		0xff, 0xc0, 0, 11, 8, 0, 1, 0, 1, 1, 1, 0x11, 0,
+		0xff, SOS, 0, 8, 1, 1, 0, 0, 63, 0,
+		0x12, 0xff, 0xd0, 0x34,
+		0xff, EOI,
+	}
+	want = append(want, "trailer"...)
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("got % x\nwant % x", out.Bytes(), want)
+	}
+	if n := b.Offset(); n != int64(len(want)) {
+		t.Errorf("got offset %d, want %d", n, len(want))
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// mapWidth is the width of the bars drawn by -map.
+const mapWidth = 40
+
+// region is a run of bytes of the file shown by -map.
+type region struct {
+	label  string
+	offset int
+	size   int
+	kind   string // label of the totals, the same for all scans
+}
+
+// regionLabel returns the -map label of the segment m with payload p.
+func regionLabel(m marker, p []byte) string {
+	if k := metadataKind(m, p); k != "" {
+		return strings.ToUpper(k)
+	}
+	if m.sym == APP2 && bytes.HasPrefix(p, mpfHeader) {
+		return "MPF"
+	}
+	if APP0 <= m.sym && m.sym <= APP0+15 {
+		return m.sym.Short()
+	}
+	return "headers"
+}
+
+// printMap prints the layout of file: headers, metadata, the entropy-coded
+// data of each scan, gaps and trailing data, in file order with bars
+// proportional to their sizes.
+func printMap(file string, r Reader, w io.Writer) error {
+	ps := newParser(ioutil.Discard)
+	var regions []*region
+	add := func(label string, offset, size int) {
+		if n := len(regions); n > 0 {
+			last := regions[n-1]
+			if last.label == label && last.offset+last.size == offset {
+				last.size += size
+				return
+			}
+		}
+		regions = append(regions, &region{label, offset, size, label})
+	}
+	var scan *restarts
+	addScan := func() {
+		rs := ps.lastScan
+		if rs == nil || rs == scan {
+			return
+		}
+		scan = rs
+		label := fmt.Sprintf("scan %d", rs.scan)
+		if ps.image > 1 {
+			label = fmt.Sprintf("#%d %s", ps.image, label)
+		}
+		add(label, rs.start, rs.end-rs.start)
+		regions[len(regions)-1].kind = "scan data"
+	}
+	ps.observers = append(ps.observers, func(m marker, p []byte) {
+		if m.sym.isRST() && ps.rst != nil {
+			return
+		}
+		addScan()
+		add(regionLabel(m, p), m.offset-2, m.end-m.offset+2)
+	})
+	err := ps.parse(r)
+	addScan()
+	ps.finish()
+	for _, g := range ps.gaps {
+		regions = append(regions, &region{"gap", g.offset, g.size, "gap"})
+	}
+	if t := ps.trailer; t != nil {
+		regions = append(regions, &region{"trailing", t.offset, t.size, "trailing"})
+	}
+	sort.SliceStable(regions, func(i, j int) bool { return regions[i].offset < regions[j].offset })
+
+	total := ps.offset
+	fmt.Fprintf(w, "%s: %d bytes\n", displayName(file), total)
+	if total == 0 {
+		return ps.result(err)
+	}
+	sizes := make(map[string]int)
+	var kinds []string
+	for _, rg := range regions {
+		fmt.Fprintf(w, "  %10d  %-12s %s\n", rg.offset, rg.label, bar(rg.size, total))
+		if _, ok := sizes[rg.kind]; !ok {
+			kinds = append(kinds, rg.kind)
+		}
+		sizes[rg.kind] += rg.size
+	}
+	// Totals by kind, largest first, show what the bytes are spent on.
+	sort.SliceStable(kinds, func(i, j int) bool { return sizes[kinds[i]] > sizes[kinds[j]] })
+	fmt.Fprintf(w, "  total:\n")
+	for _, k := range kinds {
+		fmt.Fprintf(w, "  %10s  %-12s %s\n", "", k, bar(sizes[k], total))
+	}
+	return ps.result(err)
+}
+
+// bar formats size with its share of total, as a number and a bar.
+func bar(size, total int) string {
+	frac := float64(size) / float64(total)
+	return fmt.Sprintf("%10d %5.1f%% |%-*s|", size, 100*frac, mapWidth, strings.Repeat("#", int(frac*mapWidth+0.5)))
+}
+
+// budgetCategories are the categories -sizes totals bytes by, in the
+// order they are printed.
+var budgetCategories = []string{"markers", "tables", "EXIF", "XMP", "ICC", "other APPn", "COM", "entropy-coded data", "trailing", "unattributed"}
+
+// budgetCategory returns the -sizes category of the segment m with
+// payload p.
+func budgetCategory(m marker, p []byte) string {
+	switch k := metadataKind(m, p); {
+	case k == "exif", k == "xmp", k == "icc":
+		return strings.ToUpper(k)
+	case k == "com":
+		return "COM"
+	case APP0 <= m.sym && m.sym <= APP0+15:
+		return "other APPn"
+	case m.sym == DQT || m.sym == DHT || m.sym == DAC:
+		return "tables"
+	}
+	return "markers"
+}
+
+// printSizes prints the bytes of file spent on each category of
+// budgetCategories, and their share of the file size.
+func printSizes(file string, r Reader, w io.Writer) error {
+	ps := newParser(ioutil.Discard)
+	sizes := make(map[string]int)
+	var scan *restarts
+	addScan := func() {
+		if rs := ps.lastScan; rs != nil && rs != scan {
+			scan = rs
+			sizes["entropy-coded data"] += rs.end - rs.start
+		}
+	}
+	ps.observers = append(ps.observers, func(m marker, p []byte) {
+		if m.sym.isRST() && ps.rst != nil {
+			return
+		}
+		addScan()
+		sizes[budgetCategory(m, p)] += m.end - m.offset + 2
+	})
+	err := ps.parse(r)
+	addScan()
+	ps.finish()
+	for _, g := range ps.gaps {
+		sizes["unattributed"] += g.size
+	}
+	if t := ps.trailer; t != nil {
+		sizes["trailing"] += t.size
+	}
+
+	total := ps.offset
+	fmt.Fprintf(w, "%s: %d bytes\n", displayName(file), total)
+	if total == 0 {
+		return ps.result(err)
+	}
+	for _, k := range budgetCategories {
+		fmt.Fprintf(w, "  %-20s %s\n", k, bar(sizes[k], total))
+	}
+	return ps.result(err)
+}
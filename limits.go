@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// ErrLimit stops parsing when a resource limit is exceeded.
+var ErrLimit = errors.New("resource limit exceeded")
+
+// resourceLimits bound the work and memory spent on a file, for untrusted
+// input. Zero values mean no limit.
+type resourceLimits struct {
+	segment int   // largest payload decoded; larger ones are skipped
+	markers int   // most markers read
+	scan    int   // most entropy-coded bytes of a scan buffered by -verify-scan
+	read    int64 // most bytes read
+}
+
+// limits is the resource limits of every parser, set by the command line.
+var limits = resourceLimits{segment: 0xffff - 2}
+
+// overRead reports, as a problem, whether the parser read more than the
+// read limit.
+func (ps *parser) overRead() bool {
+	if ps.limits.read <= 0 || int64(ps.offset) <= ps.limits.read {
+		return false
+	}
+	ps.problemf(diagLimit, ps.offset, "read more than %d bytes", ps.limits.read)
+	ps.stopped = true
+	return true
+}
+
+// readAll reads r to the end, for the modes that need the whole file in
+// memory. It returns ErrLimit if r holds more than the read limit.
+func readAll(r io.Reader) ([]byte, error) {
+	if limits.read <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, limits.read+1))
+	if err == nil && int64(len(data)) > limits.read {
+		return nil, ErrLimit
+	}
+	return data, err
+}
+
+// cappedBuffer is a bytes.Buffer that drops the bytes written past limit,
+// if it is not zero.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+	over  bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.limit > 0 && b.Len()+len(p) > b.limit {
+		b.over = true
+		p = p[:b.limit-b.Len()]
+	}
+	b.Buffer.Write(p)
+	return n, nil
+}
@@ -2,19 +2,48 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/dlecorfec/dumpjpeg/jpegseg"
 )
 
 type symbol int
 
 const (
-	SOI symbol = 0xd8
-	EOI symbol = 0xd9
+	SOI   symbol = 0xd8
+	EOI   symbol = 0xd9
+	RST0  symbol = 0xd0
+	RST7  symbol = 0xd7
+	DHT   symbol = 0xc4
+	DAC   symbol = 0xcc
+	JPG   symbol = 0xc8
+	DNL   symbol = 0xdc
+	DHP   symbol = 0xde
+	EXP   symbol = 0xdf
+	TEM   symbol = 0x01
+	SOF55 symbol = 0xf7 // JPEG-LS frame, in the JPGn range
+	LSE   symbol = 0xf8 // JPEG-LS parameters, in the JPGn range
+	DQT   symbol = 0xdb
+	SOS   symbol = 0xda
+	DRI   symbol = 0xdd
+	APP0  symbol = 0xe0
+	APP1  symbol = 0xe1
+	APP2  symbol = 0xe2
+	APP13 symbol = 0xed
+	COM   symbol = 0xfe
 )
 
 func (s symbol) Short() string {
@@ -25,6 +54,8 @@ func (s symbol) Short() string {
 		return "EOI"
 	case 0xc4:
 		return "DHT"
+	case DAC:
+		return "DAC"
 	case 0xdb:
 		return "DQT"
 	case 0xda:
@@ -33,6 +64,20 @@ func (s symbol) Short() string {
 		return "DRI"
 	case 0xfe:
 		return "COM"
+	case JPG:
+		return "JPG"
+	case DNL:
+		return "DNL"
+	case DHP:
+		return "DHP"
+	case EXP:
+		return "EXP"
+	case TEM:
+		return "TEM"
+	case SOF55:
+		return "SOF55"
+	case LSE:
+		return "LSE"
 	}
 	switch {
 	case 0xc0 <= s && s <= 0xcf:
@@ -41,6 +86,10 @@ func (s symbol) Short() string {
 		return fmt.Sprintf("RST%d", s-0xd0)
 	case 0xe0 <= s && s <= 0xef:
 		return fmt.Sprintf("APP%d", s-0xe0)
+	case 0xf0 <= s && s <= 0xfd:
+		return fmt.Sprintf("JPG%d", s-0xf0)
+	case 0x02 <= s && s <= 0xbf:
+		return fmt.Sprintf("RES%#x", int(s))
 	}
 	return fmt.Sprintf("UNK%#x", int(s))
 
@@ -52,12 +101,10 @@ func (s symbol) Long() string {
 		return "Start Of Image."
 	case EOI:
 		return "End Of Image."
-	case 0xc0:
-		return "Start Of Frame (Baseline)."
-	case 0xc2:
-		return "Start Of Frame (Progressive)."
 	case 0xc4:
 		return "Define Huffman Table."
+	case DAC:
+		return "Define Arithmetic coding Conditioning."
 	case 0xdb:
 		return "Define Quantization Table."
 	case 0xda:
@@ -66,29 +113,230 @@ func (s symbol) Long() string {
 		return "Define Restart Interval."
 	case 0xfe:
 		return "COMment."
+	case JPG:
+		return "Reserved for JPEG extensions."
+	case DNL:
+		return "Define Number of Lines."
+	case DHP:
+		return "Define Hierarchical Progression."
+	case EXP:
+		return "EXPand reference components."
+	case TEM:
+		return "TEMporary private use in arithmetic coding."
+	case LSE:
+		return "JPEG-LS preset parameters."
 	}
 	switch {
+	case s.isSOF():
+		p := s.process()
+		return "Start Of Frame (" + strings.ToUpper(p[:1]) + p[1:] + ")."
 	case 0xd0 <= s && s <= 0xd7:
 		return fmt.Sprintf("ReSTart (%d).", s-0xd0)
 	case 0xe0 <= s && s <= 0xef:
 		return fmt.Sprintf("APPlication specific (%d).", s-0xe0)
+	case 0xf0 <= s && s <= 0xfd:
+		return fmt.Sprintf("Reserved for JPEG extensions (%d).", s-0xf0)
+	case 0x02 <= s && s <= 0xbf:
+		return fmt.Sprintf("Reserved: %#x", int(s))
 	}
 	return fmt.Sprintf("Unknown symbol: %#x", int(s))
 
 }
 
-var ErrNotJpeg = errors.New("missing jpeg magic")
+// parseSymbol returns the symbol whose short name is name.
+func parseSymbol(name string) (symbol, bool) {
+	for s := symbol(0); s <= 0xff; s++ {
+		if strings.EqualFold(s.Short(), name) {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// hasLength reports whether the marker is followed by a length field and
+// a payload.
+func (s symbol) hasLength() bool {
+	return jpegseg.HasLength(byte(s))
+}
+
+func (s symbol) isRST() bool {
+	return jpegseg.IsRST(byte(s))
+}
+
+// reserved reports whether s is reserved for JPEG extensions or future
+// use, and so should not be found in a file.
+func (s symbol) reserved() bool {
+	switch {
+	case s == SOF55, s == LSE:
+		return false
+	case s == JPG, 0xf0 <= s && s <= 0xfd, 0x02 <= s && s <= 0xbf:
+		return true
+	}
+	return false
+}
+
+// followsScan reports whether the marker may end a scan, as opposed to
+// an unstuffed 0xff in entropy-coded data.
+func (s symbol) followsScan() bool {
+	switch {
+	case s == EOI, s == SOS, s == DHT, s == DQT, s == DRI, s == DAC, s == DNL, s == COM:
+		return true
+	case s.isSOF(), s == DHP, s == EXP, s == LSE:
+		return true
+	case APP0 <= s && s <= APP0+15:
+		return true
+	}
+	return false
+}
+
+func (s symbol) isSOF() bool {
+	return jpegseg.IsSOF(byte(s))
+}
+
+func (s symbol) arithmetic() bool {
+	return s.isSOF() && s&8 != 0
+}
+
+func (s symbol) lossless() bool {
+	return s.isSOF() && s&3 == 3
+}
+
+func (s symbol) differential() bool {
+	return s.isSOF() && s != SOF55 && s&4 != 0
+}
+
+// process describes the coding process of a frame header, such as
+// "progressive DCT, Huffman coding".
+func (s symbol) process() string {
+	if s == SOF55 {
+		return "JPEG-LS"
+	}
+	var p string
+	switch s & 3 {
+	case 0:
+		p = "baseline DCT"
+	case 1:
+		p = "extended sequential DCT"
+	case 2:
+		p = "progressive DCT"
+	case 3:
+		p = "lossless"
+	}
+	if s.differential() {
+		p = "differential " + p
+	}
+	if s.arithmetic() {
+		return p + ", arithmetic coding"
+	}
+	return p + ", Huffman coding"
+}
+
+var (
+	ErrNotJpeg = errors.New("missing jpeg magic")
+	ErrInvalid = errors.New("invalid jpeg")
+)
+
+// Exit codes.
+const (
+	exitOK      = 0
+	exitInvalid = 1 // parse errors, or differences found by -diff
+	exitNotJpeg = 2
+	exitIO      = 3
+)
+
+func exitCode(err error) int {
+	switch err {
+	case nil:
+		return exitOK
+	case ErrInvalid:
+		return exitInvalid
+	case ErrNotJpeg:
+		return exitNotJpeg
+	}
+	return exitIO
+}
+
+// fail reports err, met while processing file, and exits with the status
+// it maps to.
+func fail(file string, err error) {
+	log.Printf("%s: %v", displayName(file), err)
+	os.Exit(exitCode(err))
+}
 
 type marker struct {
+	image  int
 	sym    symbol
 	offset int
 	size   int
+	end    int    // offset following the segment
+	vendor string // APPn only: identifier the payload starts with
+	head   []byte // first bytes of the payload, for -hexdump
+
+	// Decoded payloads, depending on the marker.
+	frame    *frame
+	quant    []*quantTable
+	interval int
+	lines    int // DNL only
+	scan     *scanHeader
 }
 
 type config struct {
 	showOffset bool
 	showSize   bool
 	hex        bool
+	check      bool
+	thumbs     bool
+	extract    extractSpecs
+	strip      string
+	adds       additions
+	output     string
+	hexdump    hexdumpFlag
+	only       symbolSet
+	exclude    symbolSet
+	recursive  bool
+	exts       string
+	format     string
+	template   *template.Template
+	quiet      bool
+	mpf        bool
+	color      bool
+	summary    bool
+	verify     bool
+	gps        bool
+	gpsURL     bool
+	forensic   bool
+	identify   bool
+	coverage   bool
+	layout     bool
+	sizes      bool
+	headerOnly bool
+	orient     bool
+	decode     bool
+	hashName   string
+	hash       func() hash.Hash
+}
+
+// span formats the byte range from start to end, in hex if -hex is set.
+func (c *config) span(start, end int) string {
+	return formatSpan(start, end, c.hex)
+}
+
+// formatSpan formats the byte range from start to end, in hex if hex is
+// set.
+func formatSpan(start, end int, hex bool) string {
+	if hex {
+		return fmt.Sprintf("%#x-%#x", start, end)
+	}
+	return fmt.Sprintf("%d-%d", start, end)
+}
+
+// want reports whether markers with symbol s are selected by -only and
+// -exclude.
+func (c *config) want(s symbol) bool {
+	if len(c.only) > 0 && !c.only[s] {
+		return false
+	}
+	return !c.exclude[s]
 }
 
 type Reader interface {
@@ -96,100 +344,485 @@ type Reader interface {
 	io.Reader
 }
 
-func printInfo(file string, r Reader, c config) error {
-	var (
-		offset  int
-		lastb   byte
-		markers []marker
-	)
-	defer func() {
-		for _, m := range markers {
-			fmt.Printf("%s:%s", file, m.sym.Short())
-			if c.showOffset {
-				if c.hex {
-					fmt.Printf(":%#x", m.offset-2)
-				} else {
-					fmt.Printf(":%#d", m.offset-2)
-				}
-			}
-			if c.showSize {
-				if c.hex {
-					fmt.Printf(":%#x", m.size)
-				} else {
-					fmt.Printf(":%#d", m.size)
-				}
+func printInfo(file string, r Reader, c config, w io.Writer) error {
+	label := displayName(file)
+	ps := newParser(w)
+	if c.check || c.format != "text" || c.template != nil {
+		ps.w = ioutil.Discard
+	}
+	ps.keep = int(c.hexdump)
+	ps.coverage = c.coverage
+	ps.hex = c.hex
+	// The first scan header is the last segment read, so that the
+	// entropy-coded data is never read from files or fetched from URLs.
+	ps.headers = c.headerOnly
+	// Nothing else looks at the entropy-coded data.
+	ps.skipScans = !c.check && !c.verify && !c.coverage
+	if !c.check {
+		ps.want = c.want
+	}
+	if c.thumbs {
+		ps.handlers = append(ps.handlers, thumbExtractor(file, w))
+	}
+	var x *extractor
+	if len(c.extract) > 0 {
+		x = newExtractor(file, c.extract, w)
+		ps.handlers = append(ps.handlers, x.handle)
+	}
+	var v *scanVerifier
+	if c.verify {
+		v = newScanVerifier(ps)
+		ps.observers = append(ps.observers, v.observe)
+	}
+	// Markers are printed as they are found, each followed by its
+	// details, so that the output follows the order of the file.
+	var cw *csv.Writer
+	var werr error
+	var report *Report
+	switch {
+	case c.check:
+	case c.format == "json":
+		report = &Report{File: label, Markers: []*Marker{}, Warnings: []Diagnostic{}}
+		ps.emit = func(m marker) { report.Markers = append(report.Markers, newMarker(label, m)) }
+	case c.template != nil:
+		ps.emit = func(m marker) {
+			if werr == nil {
+				werr = c.template.Execute(w, newMarker(label, m))
 			}
-			fmt.Println()
 		}
-	}()
-	for {
-		b, err := r.ReadByte()
-		if err != nil {
+	case c.format != "text":
+		cw = newCSVWriter(w, c.format)
+		ps.emit = func(m marker) { cw.Write(csvRow(label, m, c.hex)) }
+	default:
+		ps.emit = func(m marker) { printMarker(w, label, m, ps, c) }
+	}
+	err := ps.parse(r)
+	if x != nil {
+		x.missing()
+	}
+	if v != nil {
+		v.flush()
+	}
+	ps.finish()
+	if c.mpf && len(ps.mpf) > 0 {
+		extractMPF(w, file, ps.mpf)
+	}
+	if c.check {
+		for _, p := range ps.problems {
+			fmt.Fprintf(w, "%s:%d: %s: %s [%s]\n", label, p.offset, p.severity, p.msg, p.code)
+		}
+		return ps.result(err)
+	}
+	if report != nil {
+		for _, p := range ps.problems {
+			report.Warnings = append(report.Warnings, newDiagnostic(p))
+		}
+		// One object per line, so that files can be processed as they
+		// come.
+		b, jerr := json.Marshal(report)
+		if jerr != nil {
+			return jerr
+		}
+		if _, jerr := fmt.Fprintf(w, "%s\n", b); jerr != nil {
+			return jerr
+		}
+		return ps.result(err)
+	}
+	if werr != nil {
+		return werr
+	}
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
 			return err
 		}
-		offset++
-		if lastb == 0xff && b != 0xff && b != 0 {
-			p := make([]byte, 2)
-			sym := symbol(b)
-			m := marker{
-				offset: offset,
-				sym:    sym,
-			}
-			if sym != EOI && sym != SOI {
-				_, err := io.ReadFull(r, p)
-				if err != nil {
-					return err
-				}
-				offset += 2
-				m.size = int(p[0])<<8 + int(p[1])
-			}
+		return ps.result(err)
+	}
+	if c.template != nil {
+		return ps.result(err)
+	}
+	if ps.arithmetic != 0 {
+		fmt.Fprintf(w, "%s: %s\n", label, paint(c.color, colorYellow, fmt.Sprintf("arithmetic coding (%s), not supported by many decoders", ps.arithmetic.Short())))
+	}
+	if t := ps.trailer; t != nil {
+		fmt.Fprintf(w, "%s: %s\n", label, paint(c.color, colorYellow, fmt.Sprintf("trailing: %d bytes after EOI at %d (%s)", t.size, t.offset, t.sniff())))
+	}
+	// A file that is not a JPEG stream is not a truncated one.
+	if t := ps.truncation(); t != "" && ps.magic {
+		fmt.Fprintf(w, "%s: %s\n", label, paint(c.color, colorRed, "truncated: "+t))
+	}
+	if c.coverage {
+		printGaps(w, label, ps.gaps, c)
+	}
+	return ps.result(err)
+}
 
-			markers = append(markers, m)
-			if sym == 0xda { // SOS
-				dumpSOS(r, m.size)
-			}
+// printGaps prints the byte ranges found between segments, and their
+// total.
+func printGaps(w io.Writer, label string, gaps []gap, c config) {
+	total := 0
+	for _, g := range gaps {
+		kind := "padding"
+		if g.garbage {
+			kind = "garbage"
 		}
-		lastb = b
+		fmt.Fprintf(w, "%s: %s\n", label, paint(c.color, colorYellow, fmt.Sprintf("gap: %s (%d bytes, %s)", c.span(g.offset, g.offset+g.size), g.size, kind)))
+		total += g.size
 	}
+	fmt.Fprintf(w, "%s: coverage: %d gaps, %d bytes unattributed\n", label, len(gaps), total)
 }
 
-func dumpSOS(r Reader, n int) {
-	var tmp [16]byte
-	_, _ = r.Read(tmp[:n])
-	ncomp := int(tmp[0])
-	ss := tmp[1+2*ncomp]
-	se := tmp[2+2*ncomp]
-	a := tmp[3+2*ncomp]
-	ah := a >> 4
-	al := a & 0xf
-	fmt.Printf("SOS\tss=%d\tse=%d\tah=%d\tal=%d\n", ss, se, ah, al)
-	for i := 0; i < ncomp; i++ {
-		fmt.Printf("  #%d", tmp[2*i+1])
-		td := tmp[2*i+2] >> 4
-		ta := tmp[2*i+2] & 0xf
-		fmt.Printf(" td=%d ta=%d", td, ta)
-		fmt.Printf("\n")
+// printMarker prints the text line of marker m, highlighted if a problem
+// was found at it. Markers of the images following the first one are
+// labelled with the image number.
+func printMarker(w io.Writer, label string, m marker, ps *parser, c config) {
+	name := label
+	if m.image > 1 {
+		name = fmt.Sprintf("%s#%d", label, m.image)
+	}
+	color := m.sym.color()
+	for _, p := range ps.problems {
+		if p.offset == m.offset-2 {
+			color = colorBoldRed
+		}
+	}
+	fmt.Fprintf(w, "%s:%s", name, paint(c.color, color, m.sym.Short()))
+	if m.vendor != "" {
+		fmt.Fprintf(w, " (%s)", m.vendor)
 	}
+	if c.coverage {
+		fmt.Fprintf(w, ":%s", c.span(m.offset-2, m.end))
+	} else if c.showOffset {
+		if c.hex {
+			fmt.Fprintf(w, ":%#x", m.offset-2)
+		} else {
+			fmt.Fprintf(w, ":%d", m.offset-2)
+		}
+	}
+	if c.showSize {
+		if c.hex {
+			fmt.Fprintf(w, ":%#x", m.size)
+		} else {
+			fmt.Fprintf(w, ":%d", m.size)
+		}
+	}
+	fmt.Fprintln(w)
+	hexdump(w, m.head)
 }
 
 func main() {
-	var c config
+	c := config{
+		only:    make(symbolSet),
+		exclude: make(symbolSet),
+	}
 	flag.BoolVar(&c.showOffset, "offset", false, "show offset each marker was found at.")
 	flag.BoolVar(&c.showSize, "size", false, "show size from header of each marker.")
 	flag.BoolVar(&c.hex, "hex", false, "show size and offset in hex.")
+	flag.BoolVar(&c.thumbs, "extract-thumb", false, "write embedded EXIF, JFIF and JFXX thumbnails to files.")
+	flag.BoolVar(&c.mpf, "extract-mpf", false, "write the images listed in an MPF index to files.")
+	flag.Var(&c.extract, "extract", "write the payload of a segment to a file, as MARKER[:index]=path, index counting from 1. May be repeated.")
+	flag.BoolVar(&c.check, "check", false, "validate structure and print problems.")
+	flag.BoolVar(&c.summary, "summary", false, "print a single line per file: size, coding process, subsampling, quality, metadata and its share of the file.")
+	flag.BoolVar(&c.orient, "orientation", false, "print the EXIF orientation and how to display the image upright.")
+	flag.BoolVar(&c.gps, "gps", false, "print the GPS position recorded in EXIF data, in decimal degrees.")
+	flag.BoolVar(&c.gpsURL, "gps-url", false, "with -gps, also print a map URL for the position.")
+	flag.BoolVar(&c.forensic, "forensic", false, "look for indications that the image was re-saved: quantization tables, EXIF software, coefficient histograms.")
+	flag.BoolVar(&c.identify, "identify", false, "name the encoders whose signatures come closest to the quantization and Huffman tables.")
+	flag.BoolVar(&c.decode, "decode-check", false, "decode the first image with Go's image/jpeg, printing its size and color model, or the error and the segment at which it occurred.")
+	flag.BoolVar(&c.verify, "verify-scan", false, "Huffman-decode the scans, reporting where the entropy-coded data is corrupt.")
+	flag.BoolVar(&c.quiet, "q", false, "print nothing, only set the exit status: 0 ok, 1 parse errors, 2 not a JPEG, 3 I/O error.")
+	flag.Var(&c.hexdump, "hexdump", "dump the first N bytes of each segment payload, as -hexdump=N (default "+strconv.Itoa(defaultHexdump)+").")
+	flag.Var(c.only, "only", "only show the listed markers, as -only SOS,DQT,DHT.")
+	flag.Var(c.exclude, "exclude", "do not show the listed markers, as -exclude APP0,APP1.")
+	flag.BoolVar(&c.recursive, "r", false, "scan directories recursively.")
+	flag.StringVar(&c.exts, "ext", ".jpg,.jpeg", "extensions of the files to scan in directories.")
+	flag.StringVar(&c.format, "format", "text", "output format: text, csv, tsv or json (one object per file, with its markers and warnings).")
+	flag.StringVar(&c.strip, "strip", "", "copy the input to -o without the listed metadata: "+strings.Join(metadataKinds, ",")+".")
+	flag.Var(&c.adds, "add-segment", "copy the input to -o with a segment whose payload is read from a file, as APPn=path or COM=path, replacing the segments with the same marker and identifier. May be repeated.")
+	addCOM := flag.String("add-com", "", "copy the input to -o with a COM segment holding this text.")
+	flag.StringVar(&c.output, "o", "", "output file for -strip, -add-segment, -add-com, -anonymize and -repair, output directory for -carve.")
+
+	tmpl := flag.String("template", "", "print each marker with a text/template, as -template '{{.File}} {{.Marker}} {{.Offset}}'.")
+	repair := flag.Bool("repair", false, "write a repaired copy of the input to -o.")
+	anonymize := flag.Bool("anonymize", false, "copy the input to -o without GPS data, serial numbers, owner names, thumbnails, XMP, IPTC and comments, keeping the orientation, ICC profile and Adobe segment.")
+	carve := flag.Bool("carve", false, "find JPEG images embedded in any file, writing them to the -o directory if given.")
+	mjpeg := flag.Bool("mjpeg", false, "read the input as a motion-JPEG stream, possibly with multipart boundaries, printing each frame as it arrives.")
+	diff := flag.Bool("diff", false, "compare the structure of two files.")
+	flag.BoolVar(&c.coverage, "coverage", false, "show the range of each segment and of the entropy-coded data of each scan, end offsets excluded, and report the bytes attributed to none of them.")
+	flag.BoolVar(&c.headerOnly, "header-only", false, "stop reading each file at its first SOS, skipping the entropy-coded data.")
+	flag.BoolVar(&c.layout, "map", false, "draw the layout of the file: headers, metadata, scans, gaps and trailing data, with their sizes.")
+	flag.BoolVar(&c.sizes, "sizes", false, "total the bytes spent on markers, tables, each kind of metadata, entropy-coded data and trailing data.")
+	flag.StringVar(&c.hashName, "hash", "", "print a digest of each segment and of the entropy-coded data of each image, with md5, sha1, sha256 or sha512.")
+	flag.IntVar(&limits.segment, "max-segment", limits.segment, "largest segment payload decoded, in bytes; larger ones are listed but not decoded.")
+	flag.IntVar(&limits.markers, "max-markers", 0, "stop reading a file after this many markers, 0 for no limit.")
+	flag.IntVar(&limits.scan, "max-scan-bytes", 0, "largest amount of entropy-coded data of a scan buffered by -verify-scan, 0 for no limit.")
+	flag.Int64Var(&limits.read, "max-read", 0, "stop reading a file after this many bytes, 0 for no limit.")
+	sigs := flag.String("signatures", "", "with -identify, also read encoder signatures from this file, in the format of signatures.txt.")
+	color := flag.String("color", "auto", "colorize the text output: auto, always or never.")
+	jobs := flag.Int("j", 1, "number of files to process concurrently.")
 
 	flag.Parse()
-	for _, file := range flag.Args() {
-		f, err := os.Open(file)
+	if *diff {
+		if flag.NArg() != 2 {
+			log.Fatal("-diff needs two files")
+		}
+		differ, err := diffFiles(os.Stdout, flag.Arg(0), flag.Arg(1))
 		if err != nil {
 			log.Println(err)
-			continue
+			os.Exit(exitIO)
 		}
-
-		r := bufio.NewReader(f)
-		if err := printInfo(file, r, c); err != nil && err != io.EOF {
-			log.Fatal(file, err)
+		if differ {
+			os.Exit(exitInvalid)
+		}
+		return
+	}
+	if *carve {
+		code := exitOK
+		for _, file := range expandArgs(flag.Args(), c.recursive, parseExts(c.exts)) {
+			if err := carveFile(os.Stdout, file, c.output); err != nil {
+				log.Printf("%s: %v", file, err)
+				code = exitIO
+			}
+		}
+		os.Exit(code)
+	}
+	if *mjpeg {
+		args := flag.Args()
+		if len(args) == 0 {
+			args = []string{stdinName}
+		}
+		code := exitOK
+		for _, file := range args {
+			f, err := openInput(file)
+			if err == nil {
+				err = mjpegStream(os.Stdout, file, bufio.NewReader(f))
+				f.Close()
+			}
+			if err != nil {
+				log.Printf("%s: %v", displayName(file), err)
+				code = exitIO
+			}
+		}
+		os.Exit(code)
+	}
+	if *repair {
+		if c.output == "" || flag.NArg() != 1 {
+			log.Fatal("-repair needs one input file and -o")
+		}
+		if err := repairFile(flag.Arg(0), c.output); err != nil {
+			fail(flag.Arg(0), err)
+		}
+		return
+	}
+	if *anonymize {
+		if c.output == "" || flag.NArg() != 1 {
+			log.Fatal("-anonymize needs one input file and -o")
+		}
+		file := flag.Arg(0)
+		f, err := openInput(file)
+		if err != nil {
+			fail(file, err)
+		}
+		if err := anonymizeFile(file, bufio.NewReader(f), c.output); err != nil {
+			fail(file, err)
+		}
+		f.Close()
+		return
+	}
+	if *addCOM != "" {
+		a, err := newAddition(COM, []byte(*addCOM))
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.adds = append(c.adds, a)
+	}
+	if c.strip != "" || len(c.adds) > 0 {
+		kinds := make(map[string]bool)
+		if c.strip != "" {
+			var err error
+			if kinds, err = parseKinds(c.strip); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if c.output == "" || flag.NArg() != 1 {
+			log.Fatal("-strip, -add-segment and -add-com need one input file and -o")
+		}
+		file := flag.Arg(0)
+		f, err := openInput(file)
+		if err != nil {
+			fail(file, err)
+		}
+		if err := stripFile(file, bufio.NewReader(f), c.output, kinds, c.adds); err != nil {
+			fail(file, err)
 		}
 		f.Close()
+		return
+	}
+	var err error
+	if c.color, err = parseColor(*color); err != nil {
+		log.Fatal(err)
+	}
+	if c.headerOnly && c.verify {
+		log.Fatal("-verify-scan needs the entropy-coded data, which -header-only skips")
+	}
+	if c.hash, err = parseHash(c.hashName); err != nil {
+		log.Fatal(err)
+	}
+	if c.identify {
+		if err := loadSignatures(*sigs); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *tmpl != "" {
+		if !strings.HasSuffix(*tmpl, "\n") {
+			*tmpl += "\n"
+		}
+		t, err := template.New("marker").Parse(*tmpl)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.template = t
+	}
+	switch c.format {
+	case "text", "json":
+	case "csv", "tsv":
+		if !c.quiet {
+			cw := newCSVWriter(os.Stdout, c.format)
+			cw.Write(csvHeader)
+			cw.Flush()
+		}
+	default:
+		log.Fatalf("unknown format %q", c.format)
+	}
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{stdinName}
+	}
+	code := exitOK
+	files := expandArgs(args, c.recursive, parseExts(c.exts))
+	processFiles(files, c, *jobs, func(file string, err error) {
+		if e := exitCode(err); e > code {
+			code = e
+		}
+		switch {
+		case c.quiet, err == ErrInvalid:
+		case err == ErrNotJpeg:
+			log.Printf("%s: %v", displayName(file), err)
+		default:
+			if _, ok := err.(openError); !ok {
+				err = fmt.Errorf("%s: %v", displayName(file), err)
+			}
+			log.Println(err)
+		}
+	})
+	os.Exit(code)
+}
+
+// openError wraps the error of a file that could not be opened.
+type openError struct {
+	error
+}
+
+// processFile prints the information about file, or about each entry of
+// file if it is an archive, to w. onError is called for each file or entry
+// that failed.
+func processFile(file string, c config, w io.Writer, onError func(string, error)) {
+	if archiveKind(file) != "" {
+		if err := processArchive(file, c, w, onError); err != nil {
+			onError(file, err)
+		}
+		return
+	}
+	f, err := openInput(file)
+	if err != nil {
+		onError(file, openError{err})
+		return
+	}
+	defer f.Close()
+	if err := processInput(file, newReader(f), inputSize(f), c, w); err != nil {
+		onError(file, err)
+	}
+}
+
+// processInput prints the information about the stream r of file, whose
+// size is -1 if unknown.
+func processInput(file string, r Reader, size int64, c config, w io.Writer) error {
+	if c.summary {
+		return printSummary(file, r, size, w)
+	}
+	if c.orient {
+		return printOrientation(file, r, w)
+	}
+	if c.gps {
+		return printGPS(file, r, c.gpsURL, w)
+	}
+	if c.forensic {
+		return printForensic(file, r, w)
+	}
+	if c.layout {
+		return printMap(file, r, w)
+	}
+	if c.sizes {
+		return printSizes(file, r, w)
+	}
+	if c.hash != nil {
+		return printHashes(file, r, c.hashName, c.hash, w)
+	}
+	if c.identify {
+		return printIdentify(file, r, w)
+	}
+	if c.decode {
+		return printDecodeCheck(file, r, w)
+	}
+	return printInfo(file, r, c, w)
+}
+
+// processFiles runs processFile on files using up to jobs goroutines.
+// The output of each file is buffered so that it is printed in order and
+// never interleaved with the output of other files. onError is called, in
+// order too, for each file that failed.
+func processFiles(files []string, c config, jobs int, onError func(string, error)) {
+	out := io.Writer(os.Stdout)
+	if c.quiet {
+		out = ioutil.Discard
+	}
+	if jobs <= 1 {
+		for _, file := range files {
+			processFile(file, c, out, onError)
+		}
+		return
+	}
+	type failure struct {
+		file string
+		err  error
+	}
+	type result struct {
+		out      bytes.Buffer
+		failures []failure
+	}
+	results := make([]chan *result, len(files))
+	for i := range results {
+		results[i] = make(chan *result, 1)
+	}
+	go func() {
+		sem := make(chan struct{}, jobs)
+		for i, file := range files {
+			sem <- struct{}{}
+			go func(i int, file string) {
+				res := new(result)
+				processFile(file, c, &res.out, func(file string, err error) {
+					res.failures = append(res.failures, failure{file, err})
+				})
+				results[i] <- res
+				<-sem
+			}(i, file)
+		}
+	}()
+	for i := range files {
+		res := <-results[i]
+		out.Write(res.out.Bytes())
+		for _, f := range res.failures {
+			onError(f.file, f.err)
+		}
 	}
 }
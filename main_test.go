@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestExitCode runs the command in a child process, with the arguments
+// given in DUMPJPEG_ARGS, and checks its exit status.
+func TestExitCode(t *testing.T) {
+	if args := os.Getenv("DUMPJPEG_ARGS"); args != "" {
+		os.Args = append([]string{"dumpjpeg"}, strings.Split(args, " ")...)
+		main()
+		os.Exit(exitOK)
+	}
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.jpg")
+	text := filepath.Join(dir, "text.jpg")
+	if err := ioutil.WriteFile(text, []byte("not a JPEG\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.jpg")
+	tests := []struct {
+		args string
+		want int
+	}{
+		{"-repair -o " + out + " " + missing, exitIO},
+		{"-anonymize -o " + out + " " + missing, exitIO},
+		{"-strip com -o " + out + " " + missing, exitIO},
+		{"-add-com x -o " + out + " " + missing, exitIO},
+		{"-repair -o " + out + " " + text, exitNotJpeg},
+		{"-anonymize -o " + out + " " + text, exitNotJpeg},
+		{"-strip com -o " + out + " " + text, exitNotJpeg},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestExitCode$")
+		cmd.Env = append(os.Environ(), "DUMPJPEG_ARGS="+tt.args)
+		err := cmd.Run()
+		code := exitOK
+		if e, ok := err.(*exec.ExitError); ok {
+			code = e.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.want {
+			t.Errorf("%s: got exit status %d, want %d", tt.args, code, tt.want)
+		}
+	}
+}
+
+func TestPrintInfoNotJpeg(t *testing.T) {
+	var out bytes.Buffer
+	c := config{format: "text"}
+	err := printInfo("text.jpg", bufio.NewReader(strings.NewReader("not a JPEG\n")), c, &out)
+	if err != ErrNotJpeg {
+		t.Errorf("got error %v, want %v", err, ErrNotJpeg)
+	}
+	if strings.Contains(out.String(), "truncated") {
+		t.Errorf("got %q, want no truncation reported", out.String())
+	}
+}
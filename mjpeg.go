@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// fpsWindow is the number of frames the frame rate is averaged over.
+const fpsWindow = 30
+
+// mjpegStream reads a motion-JPEG stream, a sequence of JPEG images
+// possibly separated by multipart/x-mixed-replace headers, and prints a
+// line per frame as it arrives, then statistics. The frame rate is
+// measured on arrival, so it is only reported for live streams.
+func mjpegStream(w io.Writer, file string, r *bufio.Reader) error {
+	var (
+		frames            int
+		offset            int64
+		total             int64
+		smallest, largest int
+		times             []time.Time
+		start             = time.Now()
+	)
+	for {
+		// Skip boundaries and part headers up to the next SOI.
+		for {
+			p, err := r.Peek(2)
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				mjpegStats(w, file, frames, total, smallest, largest, time.Since(start))
+				return err
+			}
+			if p[0] == 0xff && symbol(p[1]) == SOI {
+				break
+			}
+			r.ReadByte()
+			offset++
+		}
+
+		ps := newParser(ioutil.Discard)
+		ps.single = true
+		var names []string
+		ps.handlers = append(ps.handlers, func(m marker, p []byte) {
+			if !m.sym.isRST() {
+				names = append(names, m.sym.Short())
+			}
+		})
+		err := ps.parse(r)
+		ps.finish()
+		frames++
+		size := ps.offset
+		total += int64(size)
+		if frames == 1 || size < smallest {
+			smallest = size
+		}
+		if size > largest {
+			largest = size
+		}
+		times = append(times, time.Now())
+		if len(times) > fpsWindow {
+			times = times[1:]
+		}
+
+		fmt.Fprintf(w, "%s#%d\toffset=%d\tsize=%d", displayName(file), frames, offset, size)
+		if ps.fr != nil {
+			fmt.Fprintf(w, "\t%dx%d", ps.fr.width, ps.fr.lines())
+		}
+		if time.Since(start) >= time.Second && len(times) > 1 {
+			span := times[len(times)-1].Sub(times[0])
+			fmt.Fprintf(w, "\tfps=%.1f", float64(len(times)-1)/span.Seconds())
+		}
+		fmt.Fprintf(w, "\t%s", strings.Join(names, ","))
+		if n := len(ps.problems); n > 0 {
+			fmt.Fprintf(w, "\tproblems=%d (%s)", n, ps.problems[0].msg)
+		}
+		fmt.Fprintln(w)
+		offset += int64(size)
+		if !ps.eoi {
+			// The stream ended, or failed, inside this frame.
+			mjpegStats(w, file, frames, total, smallest, largest, time.Since(start))
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+			return err
+		}
+	}
+}
+
+func mjpegStats(w io.Writer, file string, frames int, total int64, smallest, largest int, elapsed time.Duration) {
+	if frames == 0 {
+		fmt.Fprintf(w, "%s: no frames\n", displayName(file))
+		return
+	}
+	fmt.Fprintf(w, "%s: %d frames\tsize min=%d avg=%d max=%d", displayName(file), frames, smallest, total/int64(frames), largest)
+	if elapsed >= time.Second {
+		fmt.Fprintf(w, "\tfps=%.1f over %s", float64(frames)/elapsed.Seconds(), elapsed.Round(time.Millisecond))
+	}
+	fmt.Fprintln(w)
+}
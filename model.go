@@ -0,0 +1,91 @@
+package main
+
+// Marker is the data model of a marker, as exposed to -template and
+// -format json.
+type Marker struct {
+	File     string `json:"-"`
+	Image    int    `json:"image"`              // index of the image in the file, from 1
+	Marker   string `json:"marker"`             // short name, such as SOF0
+	Desc     string `json:"desc"`               // long description
+	Vendor   string `json:"vendor,omitempty"`   // APPn only: identifier the payload starts with
+	Offset   int    `json:"offset"`             // offset of the marker in the file
+	End      int    `json:"end"`                // offset following the segment
+	Size     int    `json:"size"`               // length field of the segment
+	Frame    *Frame `json:"frame,omitempty"`    // SOFn only
+	Quality  int    `json:"quality,omitempty"`  // DQT only: estimated quality of the first table
+	Interval int    `json:"interval,omitempty"` // DRI only: restart interval in MCUs
+	Lines    int    `json:"lines,omitempty"`    // DNL only: number of lines of the frame
+	Scan     *Scan  `json:"scan,omitempty"`     // SOS only
+}
+
+type Frame struct {
+	Process    string      `json:"process"` // coding process, such as "baseline DCT, Huffman coding"
+	Precision  int         `json:"precision"`
+	Width      int         `json:"width"`
+	Height     int         `json:"height"`
+	Components []Component `json:"components"`
+}
+
+type Component struct {
+	ID int `json:"id"`
+	H  int `json:"h"`
+	V  int `json:"v"`
+	Tq int `json:"tq"`
+}
+
+type Scan struct {
+	Components []ScanComponent `json:"components"`
+	Ss         int             `json:"ss"`
+	Se         int             `json:"se"`
+	Ah         int             `json:"ah"`
+	Al         int             `json:"al"`
+}
+
+type ScanComponent struct {
+	ID int `json:"id"`
+	Td int `json:"td"`
+	Ta int `json:"ta"`
+}
+
+func newMarker(file string, m marker) *Marker {
+	x := &Marker{
+		File:     file,
+		Image:    m.image,
+		Marker:   m.sym.Short(),
+		Desc:     m.sym.Long(),
+		Vendor:   m.vendor,
+		Offset:   m.offset - 2,
+		End:      m.end,
+		Size:     m.size,
+		Interval: m.interval,
+		Lines:    m.lines,
+	}
+	if f := m.frame; f != nil {
+		x.Frame = &Frame{
+			Process:   f.sym.process(),
+			Precision: f.precision,
+			Width:     f.width,
+			Height:    f.height,
+		}
+		for _, c := range f.components {
+			x.Frame.Components = append(x.Frame.Components, Component{c.id, c.h, c.v, c.tq})
+		}
+	}
+	if len(m.quant) > 0 {
+		x.Quality = m.quant[0].quality()
+	}
+	if s := m.scan; s != nil {
+		x.Scan = &Scan{Ss: s.ss, Se: s.se, Ah: s.ah, Al: s.al}
+		for _, c := range s.components {
+			x.Scan.Components = append(x.Scan.Components, ScanComponent{c.id, c.td, c.ta})
+		}
+	}
+	return x
+}
+
+// Report is the data model of a file, as written by -format json.
+type Report struct {
+	File     string       `json:"file"`
+	Markers  []*Marker    `json:"markers"`
+	Warnings []Diagnostic `json:"warnings"`
+}
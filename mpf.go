@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+var ErrBadMPF = errors.New("malformed MP index")
+
+var mpfHeader = []byte("MPF\x00")
+
+const (
+	tagMPNumberOfImages = 0xb001
+	tagMPEntry          = 0xb002
+)
+
+// mpEntry is an image listed in the MP index of an MPF APP2 segment.
+type mpEntry struct {
+	attr   uint32
+	offset int // absolute offset in the file
+	size   int
+}
+
+var mpTypes = map[uint32]string{
+	0x000000: "undefined",
+	0x010001: "large thumbnail (VGA)",
+	0x010002: "large thumbnail (full HD)",
+	0x020001: "panorama frame",
+	0x020002: "disparity image",
+	0x020003: "multi-angle image",
+	0x030000: "baseline primary image",
+}
+
+func (e mpEntry) kind() string {
+	if s, ok := mpTypes[e.attr&0xffffff]; ok {
+		return s
+	}
+	return fmt.Sprintf("type %#06x", e.attr&0xffffff)
+}
+
+// mpIndex returns the TIFF structure of the MPF payload p and the value
+// of its MP Entry field, or nil if p only holds MP attributes. The value
+// is part of p.
+func mpIndex(p []byte) (*tiff, []byte, error) {
+	if !bytes.HasPrefix(p, mpfHeader) {
+		return nil, nil, ErrBadMPF
+	}
+	t, err := newTIFF(p[len(mpfHeader):])
+	if err != nil {
+		return nil, nil, err
+	}
+	ifd, _, err := t.ifd(t.first())
+	if err != nil {
+		return nil, nil, err
+	}
+	e, ok := lookup(ifd, tagMPEntry)
+	if !ok {
+		return t, nil, nil
+	}
+	return t, t.value(e), nil
+}
+
+// parseMPF decodes the MP index of an MPF segment whose payload starts at
+// offset start in the file. The segments of the secondary images only hold
+// MP attributes, without index: they yield no entries and no error.
+func parseMPF(p []byte, start int) ([]mpEntry, error) {
+	t, v, err := mpIndex(p)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	// Offsets are relative to the byte order mark following "MPF\0".
+	base := start + len(mpfHeader)
+	var entries []mpEntry
+	for i := 0; i+16 <= len(v); i += 16 {
+		me := mpEntry{
+			attr:   t.order.Uint32(v[i:]),
+			size:   int(t.order.Uint32(v[i+4:])),
+			offset: int(t.order.Uint32(v[i+8:])),
+		}
+		// The first image is the one holding the index, at offset 0.
+		if me.offset != 0 {
+			me.offset += base
+		}
+		entries = append(entries, me)
+	}
+	return entries, nil
+}
+
+// setMPEntry sets the offset and size of the i-th entry of the MP index of
+// the MPF payload p, starting at offset start in the file, from e.
+func setMPEntry(p []byte, start, i int, e mpEntry) error {
+	t, v, err := mpIndex(p)
+	if err != nil {
+		return err
+	}
+	if 16*i+16 > len(v) {
+		return ErrBadMPF
+	}
+	off := 0
+	if e.offset != 0 {
+		off = e.offset - start - len(mpfHeader)
+	}
+	t.order.PutUint32(v[16*i+4:], uint32(e.size))
+	t.order.PutUint32(v[16*i+8:], uint32(off))
+	return nil
+}
+
+func (ps *parser) dumpMPF(entries []mpEntry) {
+	fmt.Fprintf(ps.w, "MPF\timages=%d\n", len(entries))
+	for i, e := range entries {
+		fmt.Fprintf(ps.w, "  #%d %s offset=%d size=%d", i+1, e.kind(), e.offset, e.size)
+		if e.attr&(1<<29) != 0 {
+			fmt.Fprintf(ps.w, " representative")
+		}
+		fmt.Fprintf(ps.w, "\n")
+	}
+}
+
+// extractMPF writes the images listed in the MP index, except the primary
+// one, from file to the current directory.
+func extractMPF(w io.Writer, file string, entries []mpEntry) {
+	f, err := openInput(file)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		log.Printf("%s: cannot extract MPF images from the standard input", displayName(file))
+		return
+	}
+	for i, e := range entries {
+		if e.offset == 0 {
+			continue
+		}
+		if n := limits.read; n > 0 && int64(e.offset+e.size) > n {
+			log.Printf("%s: MPF image #%d: ends past the read limit of %d bytes", file, i+1, n)
+			continue
+		}
+		// The size comes from the file: copy the image rather than
+		// allocate it, so that a bogus size only yields a short read.
+		name := fmt.Sprintf("%s.mpf%d.jpg", baseName(file), i+1)
+		if err := writeSection(name, io.NewSectionReader(ra, int64(e.offset), int64(e.size))); err != nil {
+			log.Printf("%s: MPF image #%d: %v", file, i+1, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s: wrote MPF image #%d, %s (%d bytes) to %s\n", file, i+1, e.kind(), e.size, name)
+	}
+}
+
+// writeSection writes all of s to the file name, which is removed if s
+// cannot be read to the end.
+func writeSection(name string, s *io.SectionReader) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(f, s, s.Size())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/dlecorfec/dumpjpeg/jpegseg"
+)
+
+// mpfPayload returns the payload of a big-endian MPF segment. With index
+// set, it lists images of the given sizes and offsets, relative to the
+// byte order mark; otherwise it only holds the MP format version, as the
+// segments of secondary images do.
+func mpfPayload(index bool, sizes, offsets []uint32) []byte {
+	be := binary.BigEndian
+	var ifd, data bytes.Buffer
+	tags := 1
+	if index {
+		tags = 3
+	}
+	dataAt := 8 + 2 + 12*tags + 4
+	tag := func(tag, typ uint16, count uint32, value []byte) {
+		var e [12]byte
+		be.PutUint16(e[0:], tag)
+		be.PutUint16(e[2:], typ)
+		be.PutUint32(e[4:], count)
+		if len(value) > 4 {
+			be.PutUint32(e[8:], uint32(dataAt+data.Len()))
+			data.Write(value)
+		} else {
+			copy(e[8:], value)
+		}
+		ifd.Write(e[:])
+	}
+	binary.Write(&ifd, be, uint16(tags))
+	tag(0xb000, 7, 4, []byte("0100"))
+	if index {
+		n := make([]byte, 4)
+		be.PutUint32(n, uint32(len(sizes)))
+		tag(tagMPNumberOfImages, 4, 1, n)
+		var entries []byte
+		for i := range sizes {
+			var e [16]byte
+			if i == 0 {
+				be.PutUint32(e[0:], 0x20030000)
+			} else {
+				be.PutUint32(e[0:], 0x010001)
+			}
+			be.PutUint32(e[4:], sizes[i])
+			be.PutUint32(e[8:], offsets[i])
+			entries = append(entries, e[:]...)
+		}
+		tag(tagMPEntry, 7, uint32(len(entries)), entries)
+	}
+	binary.Write(&ifd, be, uint32(0))
+	p := append([]byte(nil), mpfHeader...)
+	p = append(p, "MM\x00\x2a\x00\x00\x00\x08"...)
+	p = append(p, ifd.Bytes()...)
+	return append(p, data.Bytes()...)
+}
+
+// insertAfterSOI returns data with the segments segs inserted after SOI.
+func insertAfterSOI(data []byte, segs ...[]byte) []byte {
+	out := append([]byte(nil), data[:2]...)
+	for _, s := range segs {
+		out = append(out, s...)
+	}
+	return append(out, data[2:]...)
+}
+
+// buildMPF returns a multi-picture file: primary, with an MP index, then
+// secondary, with an MP attribute segment.
+func buildMPF(primary, secondary []byte) []byte {
+	second := insertAfterSOI(secondary, jpegseg.AppendSegment(nil, byte(APP2), mpfPayload(false, nil, nil)))
+	// The index segment has the same size whatever the values.
+	size := len(primary) + len(jpegseg.AppendSegment(nil, byte(APP2), mpfPayload(true, []uint32{0, 0}, []uint32{0, 0})))
+	// Offsets are relative to the byte order mark, after SOI, the marker,
+	// the length field and "MPF\0".
+	base := 2 + 4 + len(mpfHeader)
+	index := mpfPayload(true, []uint32{uint32(size), uint32(len(second))}, []uint32{0, uint32(size - base)})
+	first := insertAfterSOI(primary, jpegseg.AppendSegment(nil, byte(APP2), index))
+	return append(first, second...)
+}
+
+func TestParseMPF(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       []byte
+		entries []mpEntry
+		err     error
+	}{
+		{
+			name: "index",
+			p:    mpfPayload(true, []uint32{1000, 200}, []uint32{0, 990}),
+			entries: []mpEntry{
+				{attr: 0x20030000, offset: 0, size: 1000},
+				{attr: 0x010001, offset: 100 + 4 + 990, size: 200},
+			},
+		},
+		{
+			name: "attributes only",
+			p:    mpfPayload(false, nil, nil),
+		},
+		{
+			name: "not TIFF",
+			p:    append(append([]byte(nil), mpfHeader...), "XX\x00\x2a"...),
+			err:  ErrBadTIFF,
+		},
+		{
+			name: "not MPF",
+			p:    []byte("Exif\x00\x00"),
+			err:  ErrBadMPF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseMPF(tt.p, 100)
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if len(entries) != len(tt.entries) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(tt.entries))
+			}
+			for i := range entries {
+				if entries[i] != tt.entries[i] {
+					t.Errorf("entry %d: got %+v, want %+v", i, entries[i], tt.entries[i])
+				}
+			}
+		})
+	}
+}
+
+// parseBytes parses data as the default mode does.
+func parseBytes(data []byte) *parser {
+	ps := newParser(ioutil.Discard)
+	ps.parse(bufio.NewReader(bytes.NewReader(data)))
+	ps.finish()
+	return ps
+}
+
+func TestMPFFile(t *testing.T) {
+	data := buildMPF(encodeGray(t, 64, 48), encodeGray(t, 32, 24))
+	ps := parseBytes(data)
+	if len(ps.problems) > 0 {
+		t.Errorf("got problems %v, want none", ps.problems)
+	}
+	if ps.image != 2 {
+		t.Errorf("got %d images, want 2", ps.image)
+	}
+	checkMPF(t, data, ps.mpf)
+}
+
+// checkMPF checks that the secondary images listed in entries are where
+// the index says.
+func checkMPF(t *testing.T, data []byte, entries []mpEntry) {
+	t.Helper()
+	if len(entries) != 2 {
+		t.Fatalf("got %d MPF entries, want 2", len(entries))
+	}
+	e := entries[1]
+	if e.offset+e.size != len(data) || !bytes.HasPrefix(data[e.offset:], []byte{0xff, byte(SOI)}) {
+		t.Errorf("MPF image 2 at %d, %d bytes: not the secondary image of a %d bytes file", e.offset, e.size, len(data))
+	}
+	if n := entries[0].size; !bytes.HasSuffix(data[:n], []byte{0xff, byte(EOI)}) {
+		t.Errorf("MPF image 1 of %d bytes does not end with EOI", n)
+	}
+}
+
+func TestExtractMPF(t *testing.T) {
+	data := buildMPF(encodeGray(t, 64, 48), encodeGray(t, 32, 24))
+	entries := parseBytes(data).mpf
+	// A size reaching past the end of the file, as a crafted index has.
+	bogus := append([]byte(nil), data...)
+	start := bytes.Index(bogus, mpfHeader)
+	if err := setMPEntry(bogus[start:], start, 1, mpEntry{offset: entries[1].offset, size: 0xfffffff0}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want []byte // nil if no image must be written
+	}{
+		{"valid", data, data[entries[1].offset:]},
+		{"bogus size", bogus, nil},
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile("in.jpg", tt.data, 0666); err != nil {
+				t.Fatal(err)
+			}
+			entries := parseBytes(tt.data).mpf
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			extractMPF(ioutil.Discard, "in.jpg", entries)
+			runtime.ReadMemStats(&after)
+			if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+				t.Errorf("allocated %d bytes, want the image copied", n)
+			}
+			got, err := ioutil.ReadFile("in.jpg.mpf2.jpg")
+			if tt.want == nil {
+				if !os.IsNotExist(err) {
+					t.Errorf("got %d bytes written, want no image", len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %d bytes, want the %d bytes of the secondary image", len(got), len(tt.want))
+			}
+			os.Remove("in.jpg.mpf2.jpg")
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const tagOrientation = 0x0112
+
+// orientations describes the values of the EXIF Orientation tag, as the
+// transformation a viewer applies to display the image upright.
+var orientations = [...]string{
+	1: "normal",
+	2: "mirror horizontal",
+	3: "rotate 180",
+	4: "mirror vertical",
+	5: "mirror horizontal and rotate 270 CW",
+	6: "rotate 90 CW",
+	7: "mirror horizontal and rotate 90 CW",
+	8: "rotate 270 CW",
+}
+
+// orientation returns the Orientation tag of IFD0, if any.
+func (x *exif) orientation() (int, bool) {
+	e, ok := lookup(x.ifd0, tagOrientation)
+	if !ok {
+		return 0, false
+	}
+	v, ok := x.uint(e, 0)
+	return int(v), ok
+}
+
+// orientationString formats an orientation value with its description.
+func orientationString(o int) string {
+	if o > 0 && o < len(orientations) {
+		return fmt.Sprintf("%d (%s)", o, orientations[o])
+	}
+	return fmt.Sprintf("%d (invalid)", o)
+}
+
+// exifOrientation returns the orientation recorded in an EXIF payload, if
+// any.
+func exifOrientation(p []byte) (int, bool) {
+	x, err := parseExif(p)
+	if err != nil {
+		return 0, false
+	}
+	return x.orientation()
+}
+
+func (ps *parser) dumpOrientation(o int) {
+	fmt.Fprintf(ps.w, "EXIF\torientation=%s\n", orientationString(o))
+}
+
+// printOrientation prints the EXIF orientation of file.
+func printOrientation(file string, r Reader, w io.Writer) error {
+	ps := newParser(ioutil.Discard)
+	ps.single = true
+	ps.headers = true
+	ps.want = func(s symbol) bool { return s == APP1 }
+	o, found := 0, false
+	ps.handlers = append(ps.handlers, func(m marker, p []byte) {
+		if !found && isExif(p) {
+			o, found = exifOrientation(p)
+		}
+	})
+	err := ps.parse(r)
+	ps.finish()
+	name := displayName(file)
+	if !found {
+		fmt.Fprintf(w, "%s: no orientation\n", name)
+	} else {
+		fmt.Fprintf(w, "%s: orientation %s\n", name, orientationString(o))
+	}
+	return ps.result(err)
+}
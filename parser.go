@@ -0,0 +1,721 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// parser walks the markers of a JPEG stream, printing details of the
+// segments it decodes and collecting structural problems.
+type parser struct {
+	imageState
+	w        io.Writer
+	offset   int
+	markers  []marker
+	seen     int
+	magic    bool
+	want     func(symbol) bool
+	keep     int
+	handlers []segmentHandler
+	// emit is called with each wanted marker as it is found, before its
+	// details are printed.
+	emit func(m marker)
+	// observers are called with every segment, wanted or not, with a nil
+	// payload for those that are not decoded.
+	observers   []segmentHandler
+	scanData    io.Writer // if set, receives the entropy-coded data of scans
+	trailerData io.Writer // if set, receives the data following the last EOI
+	pending     int       // 0xff bytes held back by feedScan
+	strict      bool      // stop at the first problem
+	single      bool      // stop after the first EOI
+	headers     bool      // stop after the first scan header, or DNL if the frame height is 0
+	stopped     bool      // stopped on purpose, at the first scan header or a limit
+	skipScans   bool      // jump over the last scan of images the MPF index gives the end of
+
+	garbage   int // length of the run of bytes found between segments
+	garbageAt int
+	partial   *partial
+	trailer   *trailer
+	mpf       []mpEntry
+	problems  []problem
+
+	arithmetic symbol // SOF of the first arithmetic-coded frame, if any
+
+	limits resourceLimits
+
+	coverage bool // print the range of the entropy-coded data of scans
+	hex      bool // print that range in hex
+	covered  int  // end of the bytes attributed to segments and scan data
+	gaps     []gap
+}
+
+// gap is a run of bytes attributed neither to a segment nor to the
+// entropy-coded data of a scan.
+type gap struct {
+	offset  int
+	size    int
+	garbage bool // false if the run only holds 0xff fill bytes
+}
+
+// imageState is the part of the parser state that is reset when another
+// image follows EOI.
+type imageState struct {
+	image    int
+	start    int // offset of SOI
+	frames   int
+	fr       *frame
+	hier     bool // a DHP segment announced a hierarchical image
+	interval int
+	scans    int
+	total    int
+	rst      *restarts
+	qt       [4]*quantTable
+	ht       [2][4]*huffTable
+	eoi      bool
+	lastScan *restarts
+	icc      [][]byte // chunks of the ICC profile read so far
+}
+
+// segmentHandler is called with each segment and its payload.
+type segmentHandler func(m marker, p []byte)
+
+// partial describes a segment cut short by the end of the stream.
+type partial struct {
+	marker
+	read int
+}
+
+func newParser(w io.Writer) *parser {
+	return &parser{
+		imageState: imageState{image: 1},
+		w:          w,
+		limits:     limits,
+	}
+}
+
+func (ps *parser) wants(s symbol) bool {
+	return ps.want == nil || ps.want(s)
+}
+
+// problemf records a problem of kind code found at offset.
+func (ps *parser) problemf(code string, offset int, format string, args ...interface{}) {
+	sev := sevError
+	if warnings[code] {
+		sev = sevWarning
+	}
+	ps.problems = append(ps.problems, problem{code, offset, fmt.Sprintf(format, args...), sev})
+}
+
+func (ps *parser) parse(r Reader) error {
+	var lastb byte
+	fill := 0 // 0xff bytes following the first of a run
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			ps.done()
+			return err
+		}
+		ps.offset++
+		if ps.overRead() {
+			return ErrLimit
+		}
+		if ps.rst != nil && ps.scanData != nil {
+			ps.feedScan(b)
+		}
+		if ps.rst == nil && ps.seen > 0 && b != 0xff && lastb != 0xff {
+			// Outside scans, segments must follow each other.
+			if ps.garbage == 0 {
+				ps.garbageAt = ps.offset - 1
+			}
+			ps.garbage++
+			if ps.strict {
+				ps.flushGarbage("")
+				return ErrInvalid
+			}
+		}
+		if ps.rst != nil && lastb == 0xff {
+			// Inside scans, 0xff bytes of data are followed by a stuffed
+			// 0x00, and fill bytes may only precede a marker.
+			switch {
+			case b == 0xff:
+				fill++
+			case b == 0 && fill > 0:
+				ps.problemf(diagStuffing, ps.offset-2-fill, "%d unstuffed 0xff bytes in scan %d", fill, ps.rst.scan)
+			case b != 0 && !symbol(b).isRST() && !symbol(b).followsScan():
+				ps.problemf(diagStuffing, ps.offset-2, "unstuffed 0xff followed by %#02x in scan %d", b, ps.rst.scan)
+				lastb, fill = b, 0
+				continue
+			}
+		}
+		if b != 0xff {
+			fill = 0
+		}
+		if lastb == 0xff && b != 0xff && b != 0 {
+			if err := ps.segment(r, symbol(b)); err != nil {
+				return err
+			}
+			if ps.eoi && ps.single {
+				ps.done()
+				return io.EOF
+			}
+			if ps.eoi {
+				ps.done()
+				var next [2]byte
+				n, _ := io.ReadFull(r, next[:])
+				if n == 2 && next[0] == 0xff && symbol(next[1]) == SOI {
+					ps.offset += 2
+					ps.imageState = imageState{image: ps.image + 1, start: ps.offset - 2}
+					if err := ps.segment(r, SOI); err != nil {
+						return err
+					}
+					lastb = 0
+					continue
+				}
+				rest := io.MultiReader(bytes.NewReader(next[:n]), r)
+				if ps.trailerData != nil {
+					rest = io.TeeReader(rest, ps.trailerData)
+				}
+				t, err := readTrailer(rest, ps.offset)
+				ps.offset += t.size
+				if t.size > 0 {
+					ps.trailer = t
+				}
+				if err != nil {
+					return err
+				}
+				return io.EOF
+			}
+		}
+		lastb = b
+	}
+}
+
+// feedScan passes byte b of the entropy-coded data to ps.scanData. RST
+// markers and unstuffed 0xff bytes are part of the data, but 0xff bytes
+// are held back until the next byte shows they do not start the marker
+// ending the scan.
+func (ps *parser) feedScan(b byte) {
+	if b == 0xff {
+		ps.pending++
+		return
+	}
+	if ps.pending > 0 {
+		if b != 0 && symbol(b).followsScan() {
+			ps.pending = 0
+			return
+		}
+		ps.scanData.Write(bytes.Repeat([]byte{0xff}, ps.pending))
+		ps.pending = 0
+	}
+	ps.scanData.Write([]byte{b})
+}
+
+// flushGarbage records the run of garbage bytes found before the marker
+// named next, if any.
+func (ps *parser) flushGarbage(next string) {
+	if ps.garbage == 0 {
+		return
+	}
+	if next == "" {
+		ps.problemf(diagGarbage, ps.garbageAt, "%d bytes of garbage between segments", ps.garbage)
+	} else {
+		ps.problemf(diagGarbage, ps.garbageAt, "%d bytes of garbage before %s", ps.garbage, next)
+	}
+	ps.garbage = 0
+}
+
+func (ps *parser) done() {
+	junk := ps.garbage > 0
+	ps.flushGarbage("")
+	ps.endScan(ps.offset)
+	ps.cover(ps.offset, junk)
+	if ps.total > 0 && ps.wants(RST0) {
+		fmt.Fprintf(ps.w, "RST\ttotal=%d\n", ps.total)
+	}
+}
+
+func (ps *parser) segment(r Reader, sym symbol) error {
+	m := marker{
+		image:  ps.image,
+		offset: ps.offset,
+		sym:    sym,
+	}
+	start := m.offset - 2
+	junk := ps.garbage > 0 || ps.seen == 0
+	ps.flushGarbage(sym.Short())
+	if sym.isRST() && ps.rst != nil {
+		ps.rst.add(sym)
+		ps.covered = start
+	} else {
+		ps.endScan(start)
+	}
+	ps.cover(start, junk)
+	if ps.seen == 0 {
+		ps.magic = sym == SOI && start == 0
+		if !ps.magic {
+			ps.problemf(diagNoSOI, start, "file does not start with SOI")
+		}
+	}
+	switch {
+	case sym == SOI && ps.seen > 0 && start != ps.start:
+		ps.problemf(diagMisplacedSOI, start, "misplaced SOI")
+	case sym.isRST() && ps.rst == nil:
+		ps.problemf(diagMisplacedRST, start, "%s outside of a scan", sym.Short())
+	case sym.reserved():
+		ps.problemf(diagReservedMarker, start, "reserved marker %s", sym.Short())
+	}
+	ps.seen++
+	if n := ps.limits.markers; n > 0 && ps.seen > n {
+		ps.problemf(diagLimit, start, "more than %d markers", n)
+		ps.stopped = true
+		return ErrLimit
+	}
+	want := ps.wants(sym)
+	// Frame, table, restart and scan headers are small and needed to
+	// follow and validate the scans, so they are decoded even when not
+	// shown.
+	decode := want || sym.isSOF() || sym == DHP || sym == DQT || sym == DHT || sym == DRI || sym == DNL || sym == SOS
+	var p []byte
+	if sym.hasLength() {
+		var l [2]byte
+		n, err := io.ReadFull(r, l[:])
+		if err != nil {
+			ps.offset += n
+			ps.partial = &partial{m, n}
+			ps.problemf(diagTruncated, start, "%s segment truncated", sym.Short())
+			return io.ErrUnexpectedEOF
+		}
+		ps.offset += 2
+		m.size = int(l[0])<<8 + int(l[1])
+		if n := ps.limits.segment; decode && n > 0 && m.size-2 > n {
+			ps.problemf(diagLargeSegment, start, "%s payload of %d bytes not decoded, over the limit of %d", sym.Short(), m.size-2, n)
+			decode = false
+		}
+		if m.size < 2 {
+			ps.problemf(diagBadLength, start, "%s segment has invalid length %d", sym.Short(), m.size)
+		} else {
+			if decode {
+				p = make([]byte, m.size-2)
+				n, err = io.ReadFull(r, p)
+			} else {
+				// Skip unwanted payloads without decoding them.
+				var c int64
+				c, err = skip(r, int64(m.size-2))
+				n = int(c)
+			}
+			ps.offset += n
+			if err != nil {
+				ps.partial = &partial{m, n + 2}
+				ps.problemf(diagLengthOverflow, start, "%s segment length %d exceeds end of file", sym.Short(), m.size)
+				return io.ErrUnexpectedEOF
+			}
+		}
+	}
+	if ps.overRead() {
+		return ErrLimit
+	}
+	m.end = ps.offset
+	ps.covered = ps.offset
+	if sym == EOI {
+		ps.eoi = true
+		ps.checkLines(start)
+	}
+	if !decode {
+		for _, h := range ps.observers {
+			h(m, nil)
+		}
+		// Payloads over the size limit are listed, but not decoded.
+		if want {
+			ps.markers = append(ps.markers, m)
+			if ps.emit != nil {
+				ps.emit(m)
+			}
+		}
+		return nil
+	}
+
+	var details func()
+	switch {
+	case sym.isSOF():
+		ps.frames++
+		if ps.frames > 1 && !ps.hier {
+			ps.problemf(diagFrame, start, "multiple frame headers")
+		}
+		if sym.differential() && !ps.hier {
+			ps.problemf(diagFrame, start, "differential frame %s without DHP", sym.Short())
+		}
+		fr, err := parseSOF(sym, p)
+		if err != nil {
+			ps.problemf(diagBadSegment, start, "%s: %v", sym.Short(), err)
+		}
+		ps.fr = fr
+		m.frame = fr
+		if want && fr != nil {
+			details = func() { ps.dumpSOF(fr) }
+		}
+		if sym.arithmetic() && ps.arithmetic == 0 {
+			ps.arithmetic = sym
+		}
+	case sym == DHP:
+		// DHP has the layout of a frame header and gives the size of the
+		// final image.
+		fr, err := parseSOF(sym, p)
+		if err != nil {
+			ps.problemf(diagBadSegment, start, "DHP: %v", err)
+		}
+		ps.hier = true
+		m.frame = fr
+	case sym == DQT:
+		tables, err := parseDQT(p)
+		if err != nil {
+			ps.problemf(diagBadSegment, start, "DQT: %v", err)
+		}
+		for _, t := range tables {
+			ps.qt[t.id] = t
+		}
+		m.quant = tables
+	case sym == DHT:
+		tables, err := parseDHT(p)
+		if err != nil {
+			ps.problemf(diagBadSegment, start, "DHT: %v", err)
+		}
+		for _, t := range tables {
+			ps.ht[t.class][t.id] = t
+		}
+	case sym == DAC:
+		conds, err := parseDAC(p)
+		if err != nil {
+			ps.problemf(diagBadSegment, start, "DAC: %v", err)
+		}
+		details = func() { ps.dumpDAC(conds) }
+	case sym == DNL:
+		m.lines = parseDRI(p)
+		ps.setLines(start, m.lines)
+		if want {
+			details = func() { ps.dumpDNL(m.lines) }
+		}
+	case sym == DRI:
+		ps.interval = parseDRI(p)
+		m.interval = ps.interval
+		if want {
+			details = ps.dumpDRI
+		}
+	case sym == APP2 && bytes.HasPrefix(p, mpfHeader):
+		entries, err := parseMPF(p, m.offset+2)
+		if err != nil {
+			ps.problemf(diagBadMetadata, start, "MPF: %v", err)
+			break
+		}
+		if entries == nil {
+			break
+		}
+		ps.mpf = entries
+		details = func() { ps.dumpMPF(entries) }
+	case sym == APP2 && bytes.HasPrefix(p, iccHeader):
+		details = func() { ps.addICC(start, p) }
+	case sym == APP1 && isExif(p):
+		if o, ok := exifOrientation(p); ok {
+			details = func() { ps.dumpOrientation(o) }
+		}
+	case sym == APP13 && bytes.HasPrefix(p, psHeader):
+		res, err := parsePhotoshop(p)
+		if err != nil {
+			ps.problemf(diagBadMetadata, start, "APP13: %v", err)
+		}
+		details = func() { ps.dumpPhotoshop(start, res) }
+	case sym == SOS:
+		sh, err := parseSOS(p)
+		if err != nil {
+			ps.problemf(diagBadSegment, start, "SOS: %v", err)
+			break
+		}
+		m.scan = sh
+		if want {
+			details = func() { ps.dumpSOS(sh) }
+		}
+		ps.checkLines(start)
+		ps.checkScan(start, sh)
+		ps.scans++
+		mcus := 0
+		if ps.fr != nil {
+			mcus = ps.fr.mcus(sh.ids())
+		}
+		ps.rst = newRestarts(ps.scans, ps.offset, ps.interval, mcus)
+	}
+	if APP0 <= sym && sym <= APP0+15 {
+		m.vendor = appVendor(p)
+	}
+	for _, h := range ps.observers {
+		h(m, p)
+	}
+	// Details are written once the marker is emitted, but run before, so
+	// that the problems they find are known when it is.
+	var out bytes.Buffer
+	if details != nil {
+		w := ps.w
+		ps.w = &out
+		details()
+		ps.w = w
+	}
+	if want {
+		if n := len(p); ps.keep > 0 {
+			if n > ps.keep {
+				n = ps.keep
+			}
+			m.head = p[:n]
+		}
+		ps.markers = append(ps.markers, m)
+		if ps.emit != nil {
+			ps.emit(m)
+		}
+	}
+	// Details follow the marker they belong to.
+	out.WriteTo(ps.w)
+	if want {
+		for _, h := range ps.handlers {
+			h(m, p)
+		}
+	}
+	if ps.strict && ps.invalid() {
+		return ErrInvalid
+	}
+	// The height of frames declared with 0 lines is only known once DNL
+	// follows their first scan.
+	waitDNL := ps.fr != nil && ps.fr.height == 0
+	if ps.headers && (sym == SOS && !waitDNL || sym == DNL) {
+		ps.stopped = true
+		return io.EOF
+	}
+	if sym == SOS && ps.skipScans && m.scan != nil {
+		return ps.skipScan(r, m.scan)
+	}
+	return nil
+}
+
+// skipScan jumps over the entropy-coded data of the scan sh, if it is the
+// last of the image and the MPF index tells where the image ends, which
+// must then be EOI. The data is neither checked nor its RST markers
+// counted.
+func (ps *parser) skipScan(r Reader, sh *scanHeader) error {
+	s, ok := r.(skipper)
+	ra, ok2 := r.(io.ReaderAt)
+	fr := ps.fr
+	// Only sequential frames of known height have a single scan holding
+	// all their components, with nothing but EOI after it.
+	if !ok || !ok2 || fr == nil || ps.hier || fr.sym&3 == 2 || fr.lines() == 0 || len(sh.components) != len(fr.components) {
+		return nil
+	}
+	end := -1
+	for _, e := range ps.mpf {
+		if e.offset == ps.start {
+			end = e.offset + e.size - 2
+		}
+	}
+	if end <= ps.offset {
+		return nil
+	}
+	var eoi [2]byte
+	if _, err := ra.ReadAt(eoi[:], int64(end)); err != nil || eoi[0] != 0xff || symbol(eoi[1]) != EOI {
+		return nil
+	}
+	n, err := s.Skip(int64(end - ps.offset))
+	ps.offset += int(n)
+	ps.rst.skipped = true
+	return err
+}
+
+// cover records the bytes between the last segment or scan data and
+// offset as a gap, if any. junk tells whether they are not all fill bytes.
+func (ps *parser) cover(offset int, junk bool) {
+	if offset > ps.covered {
+		ps.gaps = append(ps.gaps, gap{ps.covered, offset - ps.covered, junk})
+	}
+	ps.covered = offset
+}
+
+// endScan closes the current scan, if any, whose entropy-coded data ends
+// at offset end.
+func (ps *parser) endScan(end int) {
+	if ps.rst != nil {
+		ps.rst.end = end
+		ps.lastScan = ps.rst
+		ps.covered = end
+		if ps.coverage {
+			fmt.Fprintf(ps.w, "DATA\tscan=%d\trange=%s\tsize=%d\n", ps.rst.scan, formatSpan(ps.rst.start, end, ps.hex), end-ps.rst.start)
+		}
+		if ps.wants(RST0) {
+			ps.rst.print(ps.w)
+		}
+		if !ps.rst.ok() {
+			ps.problemf(diagRestart, ps.offset, "broken restart sequence in scan %d", ps.rst.scan)
+		}
+		ps.total += ps.rst.count
+		ps.rst = nil
+	}
+}
+
+// checkScan verifies that a scan follows a frame header and only uses
+// tables that have been defined.
+func (ps *parser) checkScan(offset int, sh *scanHeader) {
+	if ps.fr == nil {
+		ps.problemf(diagBadScan, offset, "SOS before frame header")
+		return
+	}
+	lossless := ps.fr.sym.lossless()
+	sequential := ps.fr.sym&3 != 2 && !lossless
+	if sequential && (sh.ss != 0 || sh.se != 63 || sh.ah != 0 || sh.al != 0) {
+		ps.problemf(diagBadScan, offset, "sequential scan with ss=%d se=%d ah=%d al=%d, want 0, 63, 0 and 0", sh.ss, sh.se, sh.ah, sh.al)
+	}
+	for _, c := range sh.components {
+		fc := ps.fr.component(c.id)
+		if fc == nil {
+			ps.problemf(diagBadScan, offset, "scan component %d not in frame", c.id)
+			continue
+		}
+		if ps.fr.sym == SOF55 {
+			// JPEG-LS scans use neither quantization nor Huffman tables.
+			continue
+		}
+		if !lossless && (fc.tq > 3 || ps.qt[fc.tq] == nil) {
+			ps.problemf(diagBadScan, offset, "component %d uses undefined quantization table %d", c.id, fc.tq)
+		}
+		if ps.fr.sym.arithmetic() {
+			continue
+		}
+		if (lossless || sh.ss == 0 && sh.ah == 0) && (c.td > 3 || ps.ht[0][c.td] == nil) {
+			ps.problemf(diagBadScan, offset, "component %d uses undefined DC Huffman table %d", c.id, c.td)
+		}
+		if (sh.se > 0 || sequential) && !lossless && (c.ta > 3 || ps.ht[1][c.ta] == nil) {
+			ps.problemf(diagBadScan, offset, "component %d uses undefined AC Huffman table %d", c.id, c.ta)
+		}
+	}
+}
+
+// finish records the problems that can only be detected once the whole
+// stream has been read.
+func (ps *parser) finish() {
+	if ps.seen == 0 {
+		ps.problemf(diagNotJpeg, 0, "%v", ErrNotJpeg)
+		return
+	}
+	if ps.frames == 0 {
+		ps.problemf(diagNoFrame, ps.offset, "no frame header")
+	}
+	if t := ps.truncation(); t != "" && ps.partial == nil {
+		ps.problemf(diagTruncated, ps.offset, "%s", t)
+	}
+}
+
+// result classifies the outcome of parsing, given the error returned by
+// parse: an I/O error, ErrNotJpeg, ErrInvalid if errors were found, or
+// nil. Warnings do not make the file invalid.
+func (ps *parser) result(err error) error {
+	switch {
+	case err != nil && err != io.EOF && err != io.ErrUnexpectedEOF && err != ErrLimit:
+		return err
+	case !ps.magic:
+		return ErrNotJpeg
+	case ps.invalid():
+		return ErrInvalid
+	}
+	return nil
+}
+
+// truncation describes how the stream was cut short, or returns "" if it
+// ended with EOI.
+func (ps *parser) truncation() string {
+	switch {
+	case ps.partial != nil:
+		m := ps.partial
+		return fmt.Sprintf("file ends inside %s segment at %d after %d of %d bytes",
+			m.sym.Short(), m.offset-2, m.read, m.size)
+	case ps.eoi, ps.stopped:
+		return ""
+	case ps.lastScan != nil && ps.lastScan.end == ps.offset:
+		rs := ps.lastScan
+		s := fmt.Sprintf("missing EOI, file ends in scan %d after %d bytes of entropy-coded data",
+			rs.scan, rs.end-rs.start)
+		if c := rs.completeness(); c >= 0 {
+			s += fmt.Sprintf(", scan about %.0f%% complete", 100*c)
+		} else if rs.decoded < 0 {
+			s += ", completeness unknown without -verify-scan"
+		} else {
+			s += ", completeness unknown"
+		}
+		return s
+	}
+	return "missing EOI"
+}
+
+// setLines records the number of lines set by a DNL segment at offset.
+// DNL may only follow the first scan of a frame declared with height 0.
+func (ps *parser) setLines(offset, n int) {
+	switch {
+	case ps.fr == nil || ps.scans == 0:
+		ps.problemf(diagFrame, offset, "DNL before the first scan")
+	case ps.scans > 1:
+		ps.problemf(diagFrame, offset, "DNL after scan %d, only allowed after the first", ps.scans)
+	case ps.fr.height != 0:
+		ps.problemf(diagFrame, offset, "DNL in a frame of declared height %d", ps.fr.height)
+	case n == 0:
+		ps.problemf(diagFrame, offset, "DNL sets 0 lines")
+	default:
+		ps.fr.dnl = n
+	}
+}
+
+// checkLines reports, at the end of the first scan, a frame of height 0
+// whose scan was not followed by DNL.
+func (ps *parser) checkLines(offset int) {
+	if ps.fr != nil && ps.scans == 1 && ps.fr.lines() == 0 {
+		ps.problemf(diagFrame, offset, "frame height 0 and no DNL after the first scan")
+	}
+}
+
+// parseDRI decodes the payload of DRI, or DNL which has the same layout.
+func parseDRI(p []byte) int {
+	if len(p) < 2 {
+		return 0
+	}
+	return int(p[0])<<8 + int(p[1])
+}
+
+func (ps *parser) dumpDRI() {
+	fmt.Fprintf(ps.w, "DRI\tinterval=%d\n", ps.interval)
+}
+
+func (ps *parser) dumpDNL(lines int) {
+	fmt.Fprintf(ps.w, "DNL\tlines=%d\n", lines)
+}
+
+func (ps *parser) dumpSOF(fr *frame) {
+	if fr.height == 0 {
+		fmt.Fprintf(ps.w, "%s\t%s\tprecision=%d\t%dx0 (height set by DNL)\n", fr.sym.Short(), fr.sym.process(), fr.precision, fr.width)
+	} else {
+		fmt.Fprintf(ps.w, "%s\t%s\tprecision=%d\t%dx%d\n", fr.sym.Short(), fr.sym.process(), fr.precision, fr.width, fr.height)
+	}
+	for _, c := range fr.components {
+		fmt.Fprintf(ps.w, "  #%d h=%d v=%d tq=%d\n", c.id, c.h, c.v, c.tq)
+	}
+}
+
+func (ps *parser) dumpDAC(conds []arithCond) {
+	fmt.Fprintf(ps.w, "DAC\n")
+	for _, c := range conds {
+		if c.class == 0 {
+			fmt.Fprintf(ps.w, "  dc #%d L=%d U=%d\n", c.id, c.value&0xf, c.value>>4)
+		} else {
+			fmt.Fprintf(ps.w, "  ac #%d Kx=%d\n", c.id, c.value)
+		}
+	}
+}
+
+func (ps *parser) dumpSOS(sh *scanHeader) {
+	fmt.Fprintf(ps.w, "SOS\tss=%d\tse=%d\tah=%d\tal=%d\n", sh.ss, sh.se, sh.ah, sh.al)
+	for _, c := range sh.components {
+		fmt.Fprintf(ps.w, "  #%d", c.id)
+		fmt.Fprintf(ps.w, " td=%d ta=%d", c.td, c.ta)
+		fmt.Fprintf(ps.w, "\n")
+	}
+}
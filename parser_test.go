@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dlecorfec/dumpjpeg/jpegseg"
+)
+
+func TestStuffing(t *testing.T) {
+	tests := []struct {
+		name   string
+		insert []byte // bytes inserted in the entropy-coded data
+		want   int    // stuffing problems
+	}{
+		{"stuffed", []byte{0xff, 0x00}, 0},
+		{"unstuffed before data", []byte{0xff, 0x12}, 1},
+		{"unstuffed run", []byte{0xff, 0xff, 0xff, 0x00}, 1},
+		// A run of 0xff followed by data must not make the next stuffed
+		// 0xff look unstuffed.
+		{"run then stuffed", []byte{0xff, 0xff, 0x12, 0xff, 0x00}, 1},
+		{"run, data, stuffed", []byte{0xff, 0xff, 0x12, 0x34, 0xff, 0x00}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeGray(t, 64, 48)
+			at := sosHeader(t, data) + 6 + 4
+			for data[at-1] == 0xff {
+				at++
+			}
+			data = append(data[:at:at], append(tt.insert, data[at:]...)...)
+			n := 0
+			for _, p := range parseBytes(data).problems {
+				if p.code == diagStuffing {
+					n++
+				}
+			}
+			if n != tt.want {
+				t.Errorf("got %d stuffing problems, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkerColor(t *testing.T) {
+	// The chunk number of the ICC segment is checked while its details are
+	// dumped.
+	icc := append(append([]byte(nil), iccHeader...), 0, 1)
+	data := insertAfterSOI(encodeGray(t, 16, 16), jpegseg.AppendSegment(nil, byte(APP2), icc))
+	var out bytes.Buffer
+	c := config{color: true, format: "text"}
+	printInfo("test.jpg", bufio.NewReader(bytes.NewReader(data)), c, &out)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, "APP2") {
+			if !strings.Contains(line, colorBoldRed+"APP2") {
+				t.Errorf("got %q, want APP2 highlighted", line)
+			}
+			return
+		}
+	}
+	t.Errorf("no APP2 line in %q", out.String())
+}
@@ -0,0 +1,103 @@
+package main
+
+// zigzag maps zigzag order positions to natural order indexes.
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// stdLuminance and stdChrominance are the example tables of the JPEG
+// standard (Annex K), in natural order, which libjpeg scales to implement
+// its quality setting.
+var stdLuminance = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+var stdChrominance = [64]int{
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}
+
+// quality estimates the libjpeg quality setting (1-100) that produces a
+// table close to t, assuming table 0 is for luminance and the others for
+// chrominance.
+func (t *quantTable) quality() int {
+	std := &stdChrominance
+	if t.id == 0 {
+		std = &stdLuminance
+	}
+	var scale float64
+	for i, v := range t.values {
+		scale += 100 * float64(v) / float64(std[zigzag[i]])
+	}
+	scale /= 64
+	var q float64
+	if scale <= 100 {
+		q = (200 - scale) / 2
+	} else {
+		q = 5000 / scale
+	}
+	switch {
+	case q < 1:
+		return 1
+	case q > 100:
+		return 100
+	}
+	return int(q + 0.5)
+}
+
+// scaledTable returns the table libjpeg derives from std at quality q,
+// in zigzag order, limited to baseline values.
+func scaledTable(std *[64]int, q int) [64]int {
+	scale := 200 - 2*q
+	if q < 50 {
+		scale = 5000 / q
+	}
+	var t [64]int
+	for i := range t {
+		v := (std[zigzag[i]]*scale + 50) / 100
+		switch {
+		case v < 1:
+			v = 1
+		case v > 255:
+			v = 255
+		}
+		t[i] = v
+	}
+	return t
+}
+
+// standard reports whether t is exactly a libjpeg table, and for which
+// quality.
+func (t *quantTable) standard() (int, bool) {
+	std := &stdChrominance
+	if t.id == 0 {
+		std = &stdLuminance
+	}
+	q := t.quality()
+	for _, c := range []int{q, q - 1, q + 1} {
+		if c >= 1 && c <= 100 && scaledTable(std, c) == t.values {
+			return c, true
+		}
+	}
+	return q, false
+}
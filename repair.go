@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/dlecorfec/dumpjpeg/jpegseg"
+)
+
+// repairer rebuilds a damaged JPEG. Unlike the parser it works on the
+// whole file in memory, as re-synchronizing after a bad segment length
+// needs to look ahead.
+type repairer struct {
+	data  []byte
+	out   bytes.Buffer
+	fixes []string
+}
+
+func (rp *repairer) fixf(offset int, format string, args ...interface{}) {
+	rp.fixes = append(rp.fixes, fmt.Sprintf("%d: ", offset)+fmt.Sprintf(format, args...))
+}
+
+// knownMarker reports whether a marker may start a segment outside scans,
+// which is what re-synchronization looks for.
+func knownMarker(s symbol) bool {
+	switch {
+	case s.isSOF(), s == DHT, s == DQT, s == DRI, s == SOS, s == EOI, s == COM, s == DAC, s == DNL, s == DHP, s == EXP:
+		return true
+	case APP0 <= s && s <= APP0+15:
+		return true
+	}
+	return false
+}
+
+// consistent reports whether the payload of a table, frame or restart
+// segment exactly matches its declared length, in which case the length is
+// trusted even if garbage follows.
+func consistent(sym symbol, p []byte) bool {
+	switch {
+	case sym == DQT:
+		_, err := parseDQT(p)
+		return err == nil
+	case sym == DHT:
+		_, err := parseDHT(p)
+		return err == nil
+	case sym == DRI:
+		return len(p) == 2
+	case sym.isSOF():
+		return len(p) >= 6 && len(p) == 6+3*int(p[5])
+	}
+	return false
+}
+
+// nextMarker returns the offset of the next known marker at or after
+// from, or the length of the data.
+func (rp *repairer) nextMarker(from int) int {
+	for i := from; i+1 < len(rp.data); i++ {
+		if rp.data[i] == 0xff && knownMarker(symbol(rp.data[i+1])) {
+			return i
+		}
+	}
+	return len(rp.data)
+}
+
+// scanEnd returns the offset of the marker ending the entropy-coded data
+// starting at from, or the length of the data. Unstuffed 0xff bytes are
+// part of the data, as the parser sees them.
+func (rp *repairer) scanEnd(from int) int {
+	d := rp.data
+	for i := from; i+1 < len(d); i++ {
+		if d[i] == 0xff && symbol(d[i+1]).followsScan() {
+			return i
+		}
+	}
+	return len(d)
+}
+
+func (rp *repairer) repair() error {
+	d := rp.data
+	pos := bytes.Index(d, []byte{0xff, byte(SOI)})
+	if pos < 0 {
+		return ErrNotJpeg
+	}
+	if pos > 0 {
+		rp.fixf(0, "dropped %d bytes before SOI", pos)
+	}
+	rp.out.Write(d[pos : pos+2])
+	pos += 2
+	eoi := false
+	for pos < len(d) {
+		var sym symbol
+		if d[pos] == 0xff && pos+1 < len(d) {
+			sym = symbol(d[pos+1])
+		}
+		if sym == 0xff {
+			// Fill byte.
+			pos++
+			continue
+		}
+		if !knownMarker(sym) && sym != SOI && !sym.isRST() {
+			next := rp.nextMarker(pos)
+			rp.fixf(pos, "dropped %d bytes of garbage", next-pos)
+			pos = next
+			continue
+		}
+		switch {
+		case sym == SOI:
+			rp.fixf(pos, "removed duplicate SOI")
+			pos += 2
+			continue
+		case sym == EOI:
+			rp.out.Write(d[pos : pos+2])
+			pos += 2
+			if bytes.HasPrefix(d[pos:], []byte{0xff, byte(SOI)}) {
+				rp.out.Write(d[pos : pos+2])
+				pos += 2
+				continue
+			}
+			// Keep trailing data as is.
+			rp.out.Write(d[pos:])
+			eoi = true
+			pos = len(d)
+			continue
+		case !sym.hasLength():
+			rp.fixf(pos, "removed stray %s", sym.Short())
+			pos += 2
+			continue
+		}
+		if pos+4 > len(d) {
+			rp.fixf(pos, "dropped truncated %s segment", sym.Short())
+			pos = len(d)
+			continue
+		}
+		l := int(d[pos+2])<<8 + int(d[pos+3])
+		end := pos + 2 + l
+		bad := l < 2 || end > len(d)
+		switch {
+		case bad:
+		case sym == SOS:
+			bad = l < 3 || l != 6+2*int(d[pos+4])
+		default:
+			bad = end < len(d) && d[end] != 0xff && !consistent(sym, d[pos+4:end])
+		}
+		if bad {
+			next := rp.nextMarker(pos + 4)
+			if sym == SOS || next == len(d) {
+				rp.fixf(pos, "dropped %s segment with bad length %d", sym.Short(), l)
+				pos = next
+				continue
+			}
+			nl := next - pos - 2
+			rp.fixf(pos, "fixed %s length %d to %d", sym.Short(), l, nl)
+			rp.out.Write(jpegseg.AppendSegment(nil, byte(sym), d[pos+4:next]))
+			pos = next
+			continue
+		}
+		rp.out.Write(d[pos:end])
+		pos = end
+		if sym == SOS {
+			e := rp.scanEnd(pos)
+			rp.out.Write(d[pos:e])
+			pos = e
+		}
+	}
+	if !eoi {
+		rp.fixf(len(d), "appended missing EOI")
+		rp.out.Write(jpegseg.AppendSegment(nil, byte(EOI), nil))
+	}
+	return nil
+}
+
+// repairFile writes a repaired copy of file to out and lists the fixes.
+func repairFile(file, out string) error {
+	f, err := openInput(file)
+	if err != nil {
+		return err
+	}
+	data, err := readAll(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	rp := &repairer{data: data}
+	if err := rp.repair(); err != nil {
+		return err
+	}
+	for _, f := range rp.fixes {
+		fmt.Printf("%s:%s\n", displayName(file), f)
+	}
+	if err := ioutil.WriteFile(out, rp.out.Bytes(), 0666); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d fixes, wrote %s\n", displayName(file), len(rp.fixes), out)
+	return nil
+}
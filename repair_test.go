@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(data []byte, sos int) []byte
+		fixes  int
+		same   bool // the output is the input
+	}{
+		{
+			name:   "valid",
+			change: func(data []byte, sos int) []byte { return data },
+			same:   true,
+		},
+		{
+			// Decoders skip an unstuffed byte; the data after it must
+			// not be dropped as garbage.
+			name: "unstuffed byte in scan",
+			change: func(data []byte, sos int) []byte {
+				at := sos + 6 + 20
+				return append(data[:at:at], append([]byte{0xff, 0x13}, data[at:]...)...)
+			},
+			same: true,
+		},
+		{
+			name: "missing EOI",
+			change: func(data []byte, sos int) []byte {
+				return data[:len(data)-2]
+			},
+			fixes: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeGray(t, 64, 48)
+			data = tt.change(data, sosHeader(t, data))
+			rp := &repairer{data: data}
+			if err := rp.repair(); err != nil {
+				t.Fatal(err)
+			}
+			if len(rp.fixes) != tt.fixes {
+				t.Errorf("got fixes %q, want %d", rp.fixes, tt.fixes)
+			}
+			if tt.same && !bytes.Equal(rp.out.Bytes(), data) {
+				t.Errorf("got %d bytes from %d, want the input", rp.out.Len(), len(data))
+			}
+		})
+	}
+}
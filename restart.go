@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// restarts tracks the RST markers found in the entropy-coded data of one
+// scan.
+type restarts struct {
+	scan     int
+	start    int
+	end      int
+	interval int
+	mcus     int
+	count    int
+	disorder int
+	next     symbol
+	decoded  int  // MCUs Huffman-decoded by -verify-scan, -1 if not decoded
+	skipped  bool // the data was jumped over, its RST markers not counted
+}
+
+func newRestarts(scan, start, interval, mcus int) *restarts {
+	return &restarts{
+		scan:     scan,
+		start:    start,
+		interval: interval,
+		mcus:     mcus,
+		next:     RST0,
+		decoded:  -1,
+	}
+}
+
+func (rs *restarts) add(s symbol) {
+	if s != rs.next {
+		rs.disorder++
+	}
+	rs.count++
+	rs.next = RST0 + (s-RST0+1)%8
+}
+
+// expected returns the number of RST markers the scan should contain
+// according to the restart interval, or -1 if it is unknown.
+func (rs *restarts) expected() int {
+	if rs.interval == 0 {
+		return 0
+	}
+	if rs.mcus == 0 {
+		return -1
+	}
+	return ceilDiv(rs.mcus, rs.interval) - 1
+}
+
+// completeness estimates the fraction of the scan read so far from the
+// MCUs decoded, if the scan was verified, or else from the restart
+// intervals, or returns -1 if it is unknown.
+func (rs *restarts) completeness() float64 {
+	if rs.decoded >= 0 && rs.mcus > 0 {
+		return float64(rs.decoded) / float64(rs.mcus)
+	}
+	e := rs.expected()
+	if rs.interval == 0 || e < 0 {
+		return -1
+	}
+	return float64(rs.count) / float64(e+1)
+}
+
+func (rs *restarts) ok() bool {
+	e := rs.expected()
+	return rs.skipped || rs.disorder == 0 && (e < 0 || e == rs.count)
+}
+
+func (rs *restarts) print(w io.Writer) {
+	if rs.count == 0 && rs.interval == 0 {
+		return
+	}
+	if rs.skipped {
+		fmt.Fprintf(w, "RST\tscan=%d\tinterval=%d\tskipped\n", rs.scan, rs.interval)
+		return
+	}
+	fmt.Fprintf(w, "RST\tscan=%d\tcount=%d", rs.scan, rs.count)
+	if rs.interval > 0 {
+		fmt.Fprintf(w, "\tinterval=%d\tmcus=%d", rs.interval, rs.mcus)
+	}
+	if e := rs.expected(); e >= 0 {
+		fmt.Fprintf(w, "\texpected=%d", e)
+	}
+	if rs.disorder > 0 {
+		fmt.Fprintf(w, "\tout-of-order=%d", rs.disorder)
+	}
+	if rs.ok() {
+		fmt.Fprintf(w, "\tok\n")
+	} else {
+		fmt.Fprintf(w, "\tBROKEN\n")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// skipper is implemented by readers that can move past bytes without
+// reading them.
+type skipper interface {
+	Skip(n int64) (int64, error)
+}
+
+// skipSource is an input that can move past bytes without reading them,
+// and read bytes at any offset.
+type skipSource interface {
+	io.Reader
+	io.ReaderAt
+	skipper
+}
+
+// skipReader buffers the reads of a skipSource, and skips large runs of
+// bytes with it.
+type skipReader struct {
+	*bufio.Reader
+	src skipSource
+}
+
+// newReader returns a buffered reader for an input opened by openInput,
+// able to skip by seeking if it is a regular file, or with range requests
+// if it is a URL.
+func newReader(f io.Reader) Reader {
+	switch f := f.(type) {
+	case *os.File:
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			return &skipReader{bufio.NewReader(f), &fileSource{f, fi.Size()}}
+		}
+	case *httpReader:
+		return &skipReader{bufio.NewReader(f), f}
+	}
+	return bufio.NewReader(f)
+}
+
+func (r *skipReader) Skip(n int64) (int64, error) {
+	b := int64(r.Buffered())
+	if n <= b+int64(r.Size()) {
+		d, err := r.Discard(int(n))
+		return int64(d), err
+	}
+	r.Discard(int(b))
+	d, err := r.src.Skip(n - b)
+	r.Reset(r.src)
+	return b + d, err
+}
+
+// ReadAt reads the input at off, whatever has been read so far.
+func (r *skipReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.src.ReadAt(p, off)
+}
+
+// fileSource skips in a regular file by seeking.
+type fileSource struct {
+	*os.File
+	size int64
+}
+
+func (f *fileSource) Skip(n int64) (int64, error) {
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	target := pos + n
+	if target > f.size {
+		target = f.size
+	}
+	if _, err := f.Seek(target, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if target-pos < n {
+		return target - pos, io.EOF
+	}
+	return n, nil
+}
+
+// skip moves past n bytes of r, seeking if r allows it.
+func skip(r Reader, n int64) (int64, error) {
+	if s, ok := r.(skipper); ok {
+		return s.Skip(n)
+	}
+	return io.CopyN(ioutil.Discard, r, n)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingReaderAt counts the bytes served from a file.
+type countingReaderAt struct {
+	*bytes.Reader
+	n *int64
+}
+
+func (r countingReaderAt) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+func TestSkipScans(t *testing.T) {
+	data := buildMPF(encodePhoto(t, 1024, 768, 85), encodePhoto(t, 640, 480, 85))
+	list := func(r Reader) string {
+		var out bytes.Buffer
+		c := config{format: "text"}
+		if err := printInfo("test.jpg", r, c, &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	want := list(bufio.NewReader(bytes.NewReader(data)))
+	if !strings.Contains(want, "test.jpg#2:EOI") {
+		t.Fatalf("no second image in %q", want)
+	}
+
+	file := filepath.Join(t.TempDir(), "mpf.jpg")
+	if err := ioutil.WriteFile(file, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got := list(newReader(f)); got != want {
+		t.Errorf("file: got\n%s\nwant\n%s", got, want)
+	}
+
+	var served int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "mpf.jpg", time.Time{}, countingReaderAt{bytes.NewReader(data), &served})
+	}))
+	defer ts.Close()
+	h, err := newHTTPReader(ts.URL + "/mpf.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := list(newReader(h)); got != want {
+		t.Errorf("URL: got\n%s\nwant\n%s", got, want)
+	}
+	if served > int64(len(data))/2 {
+		t.Errorf("served %d bytes of %d, want the scans skipped", served, len(data))
+	}
+}
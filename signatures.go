@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed signatures.txt
+var builtinSignatures string
+
+// signature describes the tables an encoder writes.
+type signature struct {
+	name    string
+	scaled  bool
+	huffman string // "standard", "optimized" or "" if unknown.
+	tables  [][64]int
+}
+
+// signatures is the database used by -identify: the embedded one, then
+// those added with -signatures.
+var signatures []*signature
+
+// annexK holds the code length counts of the Huffman tables of Annex K,
+// indexed by class and then luminance or chrominance.
+var annexK = [2][2][16]int{
+	{
+		{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+	},
+	{
+		{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7d},
+		{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 0x77},
+	},
+}
+
+// parseSignatures reads signatures in the format of signatures.txt.
+func parseSignatures(r io.Reader) ([]*signature, error) {
+	var sigs []*signature
+	var cur *signature
+	var table *[64]int
+	filled := 0
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		f := strings.Fields(s.Text())
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		if f[0] == "signature" {
+			if cur != nil && table != nil && filled < 64 {
+				return nil, fmt.Errorf("line %d: %s: short table", line, cur.name)
+			}
+			cur = &signature{name: strings.Join(f[1:], " ")}
+			if cur.name == "" {
+				return nil, fmt.Errorf("line %d: signature without a name", line)
+			}
+			sigs = append(sigs, cur)
+			table = nil
+			continue
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("line %d: %q before the first signature", line, f[0])
+		}
+		if table != nil && filled < 64 {
+			for _, v := range f {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 || n > 65535 || filled == 64 {
+					return nil, fmt.Errorf("line %d: %s: invalid table value %q", line, cur.name, v)
+				}
+				table[filled] = n
+				filled++
+			}
+			continue
+		}
+		switch {
+		case f[0] == "scaled" && len(f) == 1:
+			cur.scaled = true
+		case f[0] == "huffman" && len(f) == 2 && (f[1] == "standard" || f[1] == "optimized"):
+			cur.huffman = f[1]
+		case f[0] == "luminance" && len(f) == 1 && len(cur.tables) == 0,
+			f[0] == "chrominance" && len(f) == 1 && len(cur.tables) == 1:
+			cur.tables = append(cur.tables, [64]int{})
+			table, filled = &cur.tables[len(cur.tables)-1], 0
+		default:
+			return nil, fmt.Errorf("line %d: %s: unexpected %q", line, cur.name, s.Text())
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if table != nil && filled < 64 {
+		return nil, fmt.Errorf("%s: short table", cur.name)
+	}
+	for _, sig := range sigs {
+		if len(sig.tables) == 0 {
+			return nil, fmt.Errorf("%s: no luminance table", sig.name)
+		}
+	}
+	return sigs, nil
+}
+
+// loadSignatures sets up the signature database, adding the signatures
+// of file if it is not empty.
+func loadSignatures(file string) error {
+	sigs, err := parseSignatures(strings.NewReader(builtinSignatures))
+	if err != nil {
+		return fmt.Errorf("embedded signatures: %v", err)
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		more, err := parseSignatures(f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		sigs = append(sigs, more...)
+	}
+	signatures = sigs
+	return nil
+}
+
+// sigMatch is how close a signature comes to the tables of an image.
+type sigMatch struct {
+	sig     *signature
+	quality int     // 0 if the signature is not scaled.
+	off     float64 // mean relative difference of the values, in percent.
+}
+
+// distance returns the mean relative difference, in percent, between the
+// image tables (in zigzag order) and the signature tables (in natural
+// order) scaled at quality q, or used as is if q is 0.
+func (sig *signature) distance(tables [][64]int, q int) float64 {
+	var sum float64
+	n := 0
+	for i, t := range tables {
+		if i >= len(sig.tables) {
+			break
+		}
+		ref := sig.tables[i]
+		if q > 0 {
+			ref = scaledTable(&ref, q)
+		} else {
+			for k := range ref {
+				ref[k] = sig.tables[i][zigzag[k]]
+			}
+		}
+		for k := range t {
+			sum += math.Abs(float64(t[k]-ref[k])) / float64(ref[k])
+			n++
+		}
+	}
+	if n == 0 {
+		return math.Inf(1)
+	}
+	return 100 * sum / float64(n)
+}
+
+// match returns the quality at which sig comes closest to tables.
+func (sig *signature) match(tables [][64]int) sigMatch {
+	if !sig.scaled {
+		return sigMatch{sig: sig, off: sig.distance(tables, 0)}
+	}
+	best := sigMatch{sig: sig, off: math.Inf(1)}
+	for q := 1; q <= 100; q++ {
+		if d := sig.distance(tables, q); d < best.off {
+			best.quality, best.off = q, d
+		}
+	}
+	return best
+}
+
+// identify ranks the signatures by how close they come to tables.
+func identify(tables [][64]int) []sigMatch {
+	var m []sigMatch
+	for _, sig := range signatures {
+		m = append(m, sig.match(tables))
+	}
+	sort.SliceStable(m, func(i, j int) bool { return m[i].off < m[j].off })
+	return m
+}
+
+// printIdentify prints the encoders whose signatures come closest to the
+// quantization tables of file, and whether its Huffman tables are those
+// of Annex K.
+func printIdentify(file string, r Reader, w io.Writer) error {
+	name := displayName(file)
+	ps := newParser(ioutil.Discard)
+	ps.single = true
+	var tables [][64]int
+	huffman := ""
+	ps.observers = append(ps.observers, func(m marker, p []byte) {
+		switch {
+		// DQT may follow the frame header, but must precede the scan
+		// using its tables.
+		case m.sym == SOS && ps.fr != nil && tables == nil:
+			for i, c := range ps.fr.components {
+				if i == 2 {
+					break
+				}
+				if c.tq > 3 || ps.qt[c.tq] == nil {
+					return
+				}
+				tables = append(tables, ps.qt[c.tq].values)
+			}
+		case m.sym == DHT && p != nil && huffman != "optimized":
+			ts, _ := parseDHT(p)
+			for _, t := range ts {
+				if t.counts != annexK[t.class&1][0] && t.counts != annexK[t.class&1][1] {
+					huffman = "optimized"
+				} else if huffman == "" {
+					huffman = "standard"
+				}
+			}
+		}
+	})
+	err := ps.parse(r)
+	ps.finish()
+	if len(tables) == 0 {
+		fmt.Fprintf(w, "%s: no quantization tables\n", name)
+		return ps.result(err)
+	}
+	switch huffman {
+	case "standard":
+		fmt.Fprintf(w, "%s: huffman: standard (Annex K)\n", name)
+	case "optimized":
+		fmt.Fprintf(w, "%s: huffman: optimized\n", name)
+	}
+	matches := identify(tables)
+	shown := 0
+	for _, m := range matches {
+		if shown == 3 || m.off > 25 && shown > 0 {
+			break
+		}
+		shown++
+		s := m.sig.name
+		if m.quality > 0 {
+			s += fmt.Sprintf(", quality %d", m.quality)
+		}
+		if m.off == 0 {
+			s += ": exact match"
+		} else {
+			s += fmt.Sprintf(": %.1f%% off", m.off)
+		}
+		if m.sig.huffman != "" && huffman != "" && m.sig.huffman != huffman {
+			s += ", but " + huffman + " Huffman tables"
+		}
+		fmt.Fprintf(w, "%s: encoder: %s\n", name, s)
+	}
+	return ps.result(err)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// segmentAt returns the bounds of the segment with marker sym in data.
+func segmentAt(t *testing.T, data []byte, sym symbol) (int, int) {
+	t.Helper()
+	i := bytes.Index(data, []byte{0xff, byte(sym)})
+	if i < 0 {
+		t.Fatalf("no %s", sym.Short())
+	}
+	return i, i + 2 + int(data[i+2])<<8 + int(data[i+3])
+}
+
+func TestIdentify(t *testing.T) {
+	if err := loadSignatures(""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		change func(t *testing.T, data []byte) []byte
+	}{
+		{
+			name:   "DQT before SOF",
+			change: func(t *testing.T, data []byte) []byte { return data },
+		},
+		{
+			name: "DQT after SOF",
+			change: func(t *testing.T, data []byte) []byte {
+				qs, qe := segmentAt(t, data, DQT)
+				fs, fe := segmentAt(t, data, symbol(0xc0))
+				if qe != fs {
+					t.Fatal("DQT does not precede the frame header")
+				}
+				out := append([]byte(nil), data[:qs]...)
+				out = append(out, data[fs:fe]...)
+				out = append(out, data[qs:qe]...)
+				return append(out, data[fe:]...)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.change(t, encodeGray(t, 64, 48))
+			var out bytes.Buffer
+			if err := printIdentify("test.jpg", bufio.NewReader(bytes.NewReader(data)), &out); err != nil {
+				t.Fatal(err)
+			}
+			want := "encoder: libjpeg (IJG, libjpeg-turbo and compatible encoders), quality 75: exact match"
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("got %q, want %q", out.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	xmpHeader    = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	iccHeader    = []byte("ICC_PROFILE\x00")
+	psHeader     = []byte("Photoshop 3.0\x00")
+)
+
+// metadataKinds lists the kinds of metadata segments -strip accepts.
+var metadataKinds = []string{"exif", "xmp", "icc", "iptc", "com"}
+
+// metadataKind returns the kind of metadata held by a segment, or "".
+func metadataKind(m marker, p []byte) string {
+	switch {
+	case m.sym == APP1 && isExif(p):
+		return "exif"
+	case m.sym == APP1 && (bytes.HasPrefix(p, xmpHeader) || bytes.HasPrefix(p, xmpExtHeader)):
+		return "xmp"
+	case m.sym == APP2 && bytes.HasPrefix(p, iccHeader):
+		return "icc"
+	case m.sym == APP13 && bytes.HasPrefix(p, psHeader):
+		return "iptc"
+	case m.sym == COM:
+		return "com"
+	}
+	return ""
+}
+
+func parseKinds(list string) (map[string]bool, error) {
+	kinds := make(map[string]bool)
+	for _, k := range strings.Split(list, ",") {
+		k = strings.ToLower(strings.TrimSpace(k))
+		found := false
+		for _, known := range metadataKinds {
+			found = found || k == known
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown metadata kind %q, want one of %s", k, strings.Join(metadataKinds, ","))
+		}
+		kinds[k] = true
+	}
+	return kinds, nil
+}
+
+// stripFile copies the JPEG stream r to the file out, removing the
+// metadata segments of the given kinds and writing the segments of adds
+// in place of those they replace or after the APPn and COM segments
+// following SOI.
+func stripFile(file string, r Reader, out string, kinds map[string]bool, adds additions) (err error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(f, &err)
+	cp := newCopier(file, f)
+	var n, size, added int
+	placed := len(adds) == 0
+	cp.ps.handlers = append(cp.ps.handlers, func(m marker, p []byte) {
+		drop := kinds[metadataKind(m, p)]
+		if drop {
+			n++
+			size += 4 + len(p)
+		}
+		if m.image == 1 {
+			for _, a := range adds {
+				if !a.replaces(m, p) {
+					continue
+				}
+				// The first segment replaced takes the new payload, the
+				// others are removed.
+				if !a.done {
+					cp.add(a.sym, a.payload)
+					a.done = true
+					added++
+				}
+				drop = true
+			}
+			isMeta := m.sym == SOI || m.sym == COM || APP0 <= m.sym && m.sym <= APP0+15
+			if !placed && !isMeta {
+				for _, a := range adds {
+					if !a.done {
+						cp.add(a.sym, a.payload)
+						a.done = true
+						added++
+					}
+				}
+				placed = true
+			}
+		}
+		if !drop {
+			cp.add(m.sym, p)
+		}
+	})
+	if err := cp.copy(r); err != nil {
+		return err
+	}
+	if !placed {
+		return ErrNoPlace
+	}
+	if len(adds) > 0 {
+		fmt.Printf("%s: removed %d segments (%d bytes), added %d, wrote %s\n", displayName(file), n, size, added, out)
+		return nil
+	}
+	fmt.Printf("%s: removed %d segments (%d bytes), wrote %s\n", displayName(file), n, size, out)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// inputSize returns the size of an input opened by openInput, or -1 if it
+// cannot be known without reading it.
+func inputSize(f io.Reader) int64 {
+	switch f := f.(type) {
+	case *os.File:
+		fi, err := f.Stat()
+		if err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+	case *httpReader:
+		return f.size
+	}
+	return -1
+}
+
+// printSummary prints a single line describing the first image of file:
+// size, coding process, subsampling, quality, EXIF orientation, metadata
+// and the share of the file taken by APPn and COM segments. When size is
+// known, reading stops at the first scan.
+func printSummary(file string, r Reader, size int64, w io.Writer) error {
+	ps := newParser(ioutil.Discard)
+	ps.single = true
+	ps.headers = size >= 0
+	var kinds []string
+	var fr *frame
+	orientation := 0
+	metadata := 0
+	ps.handlers = append(ps.handlers, func(m marker, p []byte) {
+		switch {
+		case m.sym.isSOF() && fr == nil:
+			fr = m.frame
+		case APP0 <= m.sym && m.sym <= APP0+15, m.sym == COM:
+			metadata += m.size + 2
+			if k := metadataKind(m, p); k != "" && !hasString(kinds, k) {
+				kinds = append(kinds, k)
+			}
+			if m.sym == APP1 && isExif(p) && orientation == 0 {
+				orientation, _ = exifOrientation(p)
+			}
+		}
+	})
+	err := ps.parse(r)
+	ps.finish()
+	if err == io.EOF && size < 0 {
+		n, _ := io.Copy(ioutil.Discard, r)
+		ps.offset += int(n)
+	}
+	if size < 0 {
+		size = int64(ps.offset)
+	}
+
+	fields := []string{displayName(file)}
+	if fr == nil {
+		fields = append(fields, "no frame")
+	} else {
+		fields = append(fields, fmt.Sprintf("%dx%d", fr.width, fr.lines()), fr.sym.process(), fr.subsampling())
+		if len(fr.components) > 0 && fr.components[0].tq < 4 && ps.qt[fr.components[0].tq] != nil {
+			fields = append(fields, fmt.Sprintf("q=%d", ps.qt[fr.components[0].tq].quality()))
+		}
+	}
+	if orientation != 0 {
+		fields = append(fields, "orientation="+orientationString(orientation))
+	}
+	if len(kinds) > 0 {
+		fields = append(fields, strings.ToUpper(strings.Join(kinds, ",")))
+	}
+	fields = append(fields, fmt.Sprintf("size=%d", size))
+	if size > 0 {
+		fields = append(fields, fmt.Sprintf("metadata=%.1f%%", 100*float64(metadata)/float64(size)))
+	}
+	fmt.Fprintln(w, strings.Join(fields, "\t"))
+	return ps.result(err)
+}
+
+func hasString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// subsampling returns the chroma subsampling of the frame in J:a:b
+// notation, or the sampling factors of the components if it has no such
+// name.
+func (f *frame) subsampling() string {
+	switch len(f.components) {
+	case 0:
+		return ""
+	case 1:
+		return "gray"
+	}
+	y, c := f.components[0], f.components[1]
+	uniform := len(f.components) == 3 && f.components[2].h == c.h && f.components[2].v == c.v
+	if uniform && y.h > 0 && 4*c.h%y.h == 0 {
+		a := 4 * c.h / y.h
+		switch {
+		case c.v == y.v:
+			return fmt.Sprintf("4:%d:%d", a, a)
+		case 2*c.v == y.v:
+			return fmt.Sprintf("4:%d:0", a)
+		}
+	}
+	factors := make([]string, len(f.components))
+	for i, c := range f.components {
+		factors[i] = fmt.Sprintf("%dx%d", c.h, c.v)
+	}
+	return strings.Join(factors, ",")
+}
@@ -0,0 +1,102 @@
+package main
+
+import "errors"
+
+var ErrBadTable = errors.New("malformed table definition")
+
+// quantTable is a quantization table from a DQT segment, in zigzag order.
+type quantTable struct {
+	precision int // 0 for 8-bit values, 1 for 16-bit values.
+	id        int
+	values    [64]int
+}
+
+func parseDQT(p []byte) ([]*quantTable, error) {
+	var tables []*quantTable
+	for len(p) > 0 {
+		t := &quantTable{
+			precision: int(p[0] >> 4),
+			id:        int(p[0] & 0xf),
+		}
+		n := 1 + 64*(t.precision+1)
+		if t.precision > 1 || t.id > 3 || len(p) < n {
+			return tables, ErrBadTable
+		}
+		for i := range t.values {
+			if t.precision == 0 {
+				t.values[i] = int(p[1+i])
+			} else {
+				t.values[i] = int(p[1+2*i])<<8 + int(p[2+2*i])
+			}
+		}
+		tables = append(tables, t)
+		p = p[n:]
+	}
+	return tables, nil
+}
+
+// huffTable is a Huffman table from a DHT segment.
+type huffTable struct {
+	class   int // 0 for DC (or lossless), 1 for AC.
+	id      int
+	counts  [16]int
+	symbols []byte
+}
+
+func parseDHT(p []byte) ([]*huffTable, error) {
+	var tables []*huffTable
+	for len(p) > 0 {
+		if len(p) < 17 {
+			return tables, ErrBadTable
+		}
+		t := &huffTable{
+			class: int(p[0] >> 4),
+			id:    int(p[0] & 0xf),
+		}
+		n := 17
+		for i := range t.counts {
+			t.counts[i] = int(p[1+i])
+			n += t.counts[i]
+		}
+		if t.class > 1 || t.id > 3 || len(p) < n {
+			return tables, ErrBadTable
+		}
+		t.symbols = p[17:n]
+		tables = append(tables, t)
+		p = p[n:]
+	}
+	return tables, nil
+}
+
+// arithCond is an arithmetic coding conditioning table from a DAC segment.
+// For DC tables, value holds the lower bound L in its low nibble and the
+// upper bound U in its high nibble; for AC tables it is Kx.
+type arithCond struct {
+	class int // 0 for DC (or lossless), 1 for AC.
+	id    int
+	value int
+}
+
+func parseDAC(p []byte) ([]arithCond, error) {
+	if len(p)%2 != 0 {
+		return nil, ErrBadTable
+	}
+	var conds []arithCond
+	for ; len(p) > 0; p = p[2:] {
+		c := arithCond{
+			class: int(p[0] >> 4),
+			id:    int(p[0] & 0xf),
+			value: int(p[1]),
+		}
+		switch {
+		case c.class > 1 || c.id > 3:
+			return conds, ErrBadTable
+		case c.class == 0 && c.value&0xf > c.value>>4:
+			return conds, ErrBadTable
+		case c.class == 1 && (c.value < 1 || c.value > 63):
+			return conds, ErrBadTable
+		}
+		conds = append(conds, c)
+	}
+	return conds, nil
+}
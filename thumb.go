@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+)
+
+// thumbnail is an embedded preview image, either JPEG data or RGB
+// pixels encoded as a binary PPM.
+type thumbnail struct {
+	source string
+	ext    string
+	data   []byte
+}
+
+var (
+	jfifHeader = []byte("JFIF\x00")
+	jfxxHeader = []byte("JFXX\x00")
+)
+
+func exifThumbnail(p []byte) *thumbnail {
+	x, err := parseExif(p)
+	if err != nil {
+		return nil
+	}
+	data := x.thumbnail()
+	if len(data) == 0 {
+		return nil
+	}
+	return &thumbnail{source: "EXIF", ext: "jpg", data: data}
+}
+
+// jfifThumbnail returns the thumbnail of a JFIF or JFXX APP0 segment.
+func jfifThumbnail(p []byte) *thumbnail {
+	switch {
+	case bytes.HasPrefix(p, jfifHeader) && len(p) >= 14:
+		return rgbThumbnail("JFIF", p[12:])
+	case bytes.HasPrefix(p, jfxxHeader) && len(p) >= 6:
+		ext := p[6:]
+		switch p[5] {
+		case 0x10:
+			if len(ext) == 0 {
+				return nil
+			}
+			return &thumbnail{source: "JFXX", ext: "jpg", data: ext}
+		case 0x11:
+			return paletteThumbnail(ext)
+		case 0x13:
+			return rgbThumbnail("JFXX", ext)
+		}
+	}
+	return nil
+}
+
+func rgbThumbnail(source string, p []byte) *thumbnail {
+	if len(p) < 2 {
+		return nil
+	}
+	w, h := int(p[0]), int(p[1])
+	if w == 0 || h == 0 || len(p) < 2+3*w*h {
+		return nil
+	}
+	return &thumbnail{source: source, ext: "ppm", data: ppm(w, h, p[2:2+3*w*h])}
+}
+
+func paletteThumbnail(p []byte) *thumbnail {
+	if len(p) < 2+768 {
+		return nil
+	}
+	w, h := int(p[0]), int(p[1])
+	palette, idx := p[2:2+768], p[2+768:]
+	if w == 0 || h == 0 || len(idx) < w*h {
+		return nil
+	}
+	rgb := make([]byte, 0, 3*w*h)
+	for _, i := range idx[:w*h] {
+		rgb = append(rgb, palette[3*int(i):3*int(i)+3]...)
+	}
+	return &thumbnail{source: "JFXX", ext: "ppm", data: ppm(w, h, rgb)}
+}
+
+func ppm(w, h int, rgb []byte) []byte {
+	return append([]byte(fmt.Sprintf("P6\n%d %d\n255\n", w, h)), rgb...)
+}
+
+// thumbExtractor returns a segment handler writing the thumbnails found in
+// file to the current directory.
+func thumbExtractor(file string, w io.Writer) segmentHandler {
+	n := 0
+	return func(m marker, p []byte) {
+		var t *thumbnail
+		switch m.sym {
+		case APP0:
+			t = jfifThumbnail(p)
+		case APP1:
+			t = exifThumbnail(p)
+		}
+		if t == nil {
+			return
+		}
+		n++
+		name := fmt.Sprintf("%s.thumb%d.%s", baseName(file), n, t.ext)
+		if err := ioutil.WriteFile(name, t.data, 0666); err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Fprintf(w, "%s: wrote %s thumbnail (%d bytes) to %s\n", displayName(file), t.source, len(t.data), name)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// trailer summarizes the data found after EOI.
+type trailer struct {
+	offset int
+	size   int
+	head   []byte
+	tail   []byte
+}
+
+const (
+	trailerHead = 4096
+	trailerTail = 32
+)
+
+func readTrailer(r io.Reader, offset int) (*trailer, error) {
+	t := &trailer{offset: offset}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			t.add(buf[:n])
+		}
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return t, err
+		}
+	}
+}
+
+func (t *trailer) add(p []byte) {
+	t.size += len(p)
+	if len(t.head) < trailerHead {
+		n := trailerHead - len(t.head)
+		if n > len(p) {
+			n = len(p)
+		}
+		t.head = append(t.head, p[:n]...)
+	}
+	t.tail = append(t.tail, p...)
+	if len(t.tail) > trailerTail {
+		t.tail = append(t.tail[:0], t.tail[len(t.tail)-trailerTail:]...)
+	}
+}
+
+var trailerMagics = []struct {
+	magic string
+	desc  string
+}{
+	{"\xff\xd8\xff", "another JPEG image"},
+	{"PK\x03\x04", "ZIP archive"},
+	{"Rar!\x1a\x07", "RAR archive"},
+	{"7z\xbc\xaf\x27\x1c", "7-Zip archive"},
+	{"\x1f\x8b", "gzip data"},
+	{"%PDF", "PDF document"},
+	{"\x89PNG\r\n\x1a\n", "PNG image"},
+	{"GIF8", "GIF image"},
+	{"<?xpacket", "XMP packet"},
+	{"<x:xmpmeta", "XMP packet"},
+}
+
+// sniff guesses what the trailing data is.
+func (t *trailer) sniff() string {
+	for _, m := range trailerMagics {
+		if bytes.HasPrefix(t.head, []byte(m.magic)) {
+			return m.desc
+		}
+	}
+	switch {
+	case bytes.Contains(t.tail, []byte("SEFT")):
+		return "Samsung trailer"
+	case bytes.Contains(t.tail, []byte("PK\x05\x06")), bytes.Contains(t.head, []byte("PK\x03\x04")):
+		return "embedded ZIP archive"
+	case bytes.Contains(t.head, []byte("<x:xmpmeta")):
+		return "embedded XMP packet"
+	case bytes.Contains(t.head, []byte("\xff\xd8\xff")):
+		return "embedded JPEG image"
+	case len(bytes.Trim(t.head, "\x00")) == 0:
+		return "zero padding"
+	case len(bytes.Trim(t.head, "\xff")) == 0:
+		return "0xff padding"
+	case printable(t.head):
+		return "text"
+	}
+	return "unknown binary data"
+}
+
+func printable(p []byte) bool {
+	for _, b := range p {
+		if (b < 0x20 || b > 0x7e) && b != '\n' && b != '\r' && b != '\t' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Range requests start small, as most readers only need the headers, and
+// grow for readers that go through the whole image.
+const (
+	minFetch = 16 * 1024
+	maxFetch = 1024 * 1024
+)
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// urlBase returns the last element of the path of a URL.
+func urlBase(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || path.Base(u.Path) == "/" || path.Base(u.Path) == "." {
+		return "remote"
+	}
+	return path.Base(u.Path)
+}
+
+// httpReader reads a remote file lazily with HTTP range requests, so that
+// only the parts of the file actually read are downloaded. If the server
+// does not support ranges, it falls back to streaming the whole body.
+type httpReader struct {
+	url   string
+	off   int64 // offset of buf in the file
+	buf   []byte
+	size  int64 // -1 if unknown
+	fetch int
+	body  io.ReadCloser
+}
+
+// newHTTPReader fetches the first bytes of the file at url.
+func newHTTPReader(url string) (*httpReader, error) {
+	h := &httpReader{url: url, size: -1, fetch: minFetch}
+	if err := h.fill(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return h, nil
+}
+
+// get requests n bytes at off, returning the response if it is a partial
+// content or, when off is 0, a full content one.
+func (h *httpReader) get(off int64, n int) (*http.Response, error) {
+	req, err := http.NewRequest("GET", h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(n)-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if size, ok := contentRangeSize(resp.Header.Get("Content-Range")); ok {
+			h.size = size
+		}
+		return resp, nil
+	case http.StatusOK:
+		if off == 0 {
+			return resp, nil
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return nil, io.EOF
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("%s: %s", h.url, resp.Status)
+}
+
+// contentRangeSize returns the complete length from a Content-Range header.
+func contentRangeSize(s string) (int64, bool) {
+	i := strings.LastIndexByte(s, '/')
+	if i < 0 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(s[i+1:], 10, 64)
+	return size, err == nil
+}
+
+// fill fetches the next bytes once buf has been consumed.
+func (h *httpReader) fill() error {
+	if h.size >= 0 && h.off >= h.size {
+		return io.EOF
+	}
+	resp, err := h.get(h.off, h.fetch)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		h.body = resp.Body
+		return nil
+	}
+	defer resp.Body.Close()
+	h.buf, err = ioutil.ReadAll(io.LimitReader(resp.Body, int64(h.fetch)))
+	if err != nil {
+		return err
+	}
+	if len(h.buf) == 0 {
+		return io.EOF
+	}
+	if h.fetch < maxFetch {
+		h.fetch *= 2
+	}
+	return nil
+}
+
+func (h *httpReader) Read(p []byte) (int, error) {
+	if h.body != nil {
+		return h.body.Read(p)
+	}
+	if len(h.buf) == 0 {
+		if err := h.fill(); err != nil {
+			return 0, err
+		}
+		if h.body != nil {
+			return h.body.Read(p)
+		}
+	}
+	n := copy(p, h.buf)
+	h.off += int64(n)
+	h.buf = h.buf[n:]
+	return n, nil
+}
+
+// Skip moves past n bytes. Unless the server does not support ranges,
+// the bytes past the buffer are not downloaded: the next read fetches the
+// bytes that follow with a new range request, small again.
+func (h *httpReader) Skip(n int64) (int64, error) {
+	if h.body != nil {
+		return io.CopyN(ioutil.Discard, h.body, n)
+	}
+	if n <= int64(len(h.buf)) {
+		h.buf = h.buf[n:]
+		h.off += n
+		return n, nil
+	}
+	h.off += n
+	h.buf = nil
+	h.fetch = minFetch
+	if h.size >= 0 && h.off > h.size {
+		d := n - (h.off - h.size)
+		h.off = h.size
+		return d, io.EOF
+	}
+	return n, nil
+}
+
+// ReadAt fetches len(p) bytes at off with a single range request.
+func (h *httpReader) ReadAt(p []byte, off int64) (int, error) {
+	resp, err := h.get(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("%s: server does not support range requests", h.url)
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (h *httpReader) Close() error {
+	if h.body != nil {
+		return h.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "bytes"
+
+// appVendors maps the identifiers APPn payloads start with to the names
+// they are shown under.
+var appVendors = []struct {
+	prefix []byte
+	name   string
+}{
+	{exifHeader, "Exif"},
+	{[]byte("Exif\x00\xff"), "Exif"},
+	{jfifHeader, "JFIF"},
+	{jfxxHeader, "JFXX"},
+	{iccHeader, "ICC_PROFILE"},
+	{xmpHeader, "XMP"},
+	{xmpExtHeader, "XMP extension"},
+	{psHeader, "Photoshop"},
+	{mpfHeader, "MPF"},
+	{[]byte("urn:iso:std:iso:ts:21496:-1\x00"), "HDR gain map"},
+	{[]byte("Adobe"), "Adobe"},
+	{[]byte("Ducky"), "Ducky"},
+	{[]byte("FLIR\x00"), "FLIR"},
+	{[]byte("AVI1"), "AVI1"},
+}
+
+// maxIdent is the number of leading printable bytes shown for payloads
+// with an unknown identifier.
+const maxIdent = 16
+
+// appVendor returns the name of the identifier an APPn payload starts
+// with, or its first printable bytes if it is unknown.
+func appVendor(p []byte) string {
+	for _, v := range appVendors {
+		if bytes.HasPrefix(p, v.prefix) {
+			return v.name
+		}
+	}
+	n := 0
+	for n < len(p) && n < maxIdent && p[n] >= 0x20 && p[n] < 0x7f {
+		n++
+	}
+	return string(bytes.TrimSpace(p[:n]))
+}
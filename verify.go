@@ -0,0 +1,484 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	errScanEnd    = errors.New("entropy-coded data ends")
+	errMarker     = errors.New("marker inside entropy-coded data")
+	errBadCode    = errors.New("invalid Huffman code")
+	errCoefIndex  = errors.New("coefficient index out of range")
+	errBadDC      = errors.New("invalid DC difference category")
+	errBadRefine  = errors.New("invalid successive approximation refinement")
+	errBadRestart = errors.New("missing restart marker")
+)
+
+// scanPadding is the number of bytes encoders are known to leave after
+// the last MCU of a scan, such as a flushed 0xff 0x00, without it being
+// worth a warning.
+const scanPadding = 4
+
+// huffDecoder decodes the codes of a Huffman table, using the canonical
+// code ranges of each length (F.2.2.3 of the standard).
+type huffDecoder struct {
+	mincode [17]int
+	maxcode [17]int // -1 if there are no codes of this length
+	valptr  [17]int
+	symbols []byte
+}
+
+func newHuffDecoder(t *huffTable) *huffDecoder {
+	d := &huffDecoder{symbols: t.symbols}
+	code, k := 0, 0
+	for l := 1; l <= 16; l++ {
+		n := t.counts[l-1]
+		d.valptr[l] = k
+		d.mincode[l] = code
+		code += n
+		k += n
+		d.maxcode[l] = code - 1
+		if n == 0 {
+			d.maxcode[l] = -1
+		}
+		code <<= 1
+	}
+	return d
+}
+
+// bitReader reads the bits of entropy-coded data, removing the stuffed
+// zero bytes. It stops at markers.
+type bitReader struct {
+	data []byte
+	pos  int // next byte of data
+	acc  byte
+	n    uint // bits left in acc
+}
+
+func (br *bitReader) bit() (int, error) {
+	if br.n == 0 {
+		if br.pos >= len(br.data) {
+			return 0, errScanEnd
+		}
+		b := br.data[br.pos]
+		if b == 0xff {
+			if br.pos+1 >= len(br.data) {
+				return 0, errScanEnd
+			}
+			if br.data[br.pos+1] != 0 {
+				return 0, errMarker
+			}
+			br.pos++
+		}
+		br.pos++
+		br.acc, br.n = b, 8
+	}
+	br.n--
+	return int(br.acc>>br.n) & 1, nil
+}
+
+func (br *bitReader) bits(n int) (int, error) {
+	v := 0
+	for i := 0; i < n; i++ {
+		b, err := br.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	return v, nil
+}
+
+func (br *bitReader) decode(d *huffDecoder) (byte, error) {
+	code := 0
+	for l := 1; l <= 16; l++ {
+		b, err := br.bit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | b
+		if d.maxcode[l] >= 0 && code <= d.maxcode[l] && code >= d.mincode[l] {
+			i := d.valptr[l] + code - d.mincode[l]
+			if i >= len(d.symbols) {
+				return 0, errBadCode
+			}
+			return d.symbols[i], nil
+		}
+	}
+	return 0, errBadCode
+}
+
+// restart skips the padding bits and the RST marker expected after each
+// restart interval.
+func (br *bitReader) restart(next symbol) error {
+	br.n = 0
+	if br.pos+1 >= len(br.data) || br.data[br.pos] != 0xff || symbol(br.data[br.pos+1]) != next {
+		return errBadRestart
+	}
+	br.pos += 2
+	return nil
+}
+
+// scanVerifier Huffman-decodes the scans of the images as they are
+// parsed, to locate corruption inside the entropy-coded data. It keeps,
+// for progressive images, which coefficients of each block are already
+// non-zero, as AC refinement scans depend on it.
+type scanVerifier struct {
+	ps      *parser
+	fr      *frame
+	nonzero map[int][]uint64 // by component id, a bit per coefficient
+	failed  map[int]int      // by component id, the scan that failed
+	// coef, if set, receives the quantized AC coefficients of sequential
+	// scans, by component id and zigzag index.
+	coef func(id, k, v int)
+	scan *scanDecoder
+}
+
+// scanDecoder holds a scan being read and the state it is decoded with.
+type scanDecoder struct {
+	index    int
+	start    int
+	sh       *scanHeader
+	interval int
+	dc, ac   [4]*huffDecoder
+	data     cappedBuffer
+}
+
+func newScanVerifier(ps *parser) *scanVerifier {
+	return &scanVerifier{ps: ps}
+}
+
+// observe is a segment observer starting the verification of each scan and
+// decoding it when the next marker shows it is complete.
+func (v *scanVerifier) observe(m marker, p []byte) {
+	if m.sym.isRST() {
+		return
+	}
+	v.flush()
+	switch {
+	case m.sym.isSOF():
+		v.fr = m.frame
+		v.nonzero = make(map[int][]uint64)
+		v.failed = make(map[int]int)
+	case m.sym == SOS && m.scan != nil && v.fr != nil:
+		sd := &scanDecoder{
+			index:    v.ps.scans,
+			start:    v.ps.offset,
+			sh:       m.scan,
+			interval: v.ps.interval,
+		}
+		for i := 0; i < 4; i++ {
+			if t := v.ps.ht[0][i]; t != nil {
+				sd.dc[i] = newHuffDecoder(t)
+			}
+			if t := v.ps.ht[1][i]; t != nil {
+				sd.ac[i] = newHuffDecoder(t)
+			}
+		}
+		sd.data.limit = v.ps.limits.scan
+		v.scan = sd
+		v.ps.scanData = &sd.data
+		v.ps.pending = 0
+	}
+}
+
+// flush decodes the pending scan, if any.
+func (v *scanVerifier) flush() {
+	sd := v.scan
+	if sd == nil {
+		return
+	}
+	v.scan = nil
+	v.ps.scanData = nil
+	ps := v.ps
+	switch {
+	case v.fr.sym.arithmetic(), v.fr.sym.lossless():
+		fmt.Fprintf(ps.w, "VERIFY\tscan=%d\tskipped (%s)\n", sd.index, v.fr.sym.process())
+		return
+	}
+	// Sequential scans always code a DC difference and AC coefficients,
+	// whatever the spectral selection says.
+	sequential := v.fr.sym&3 != 2
+	needDC := sequential || sd.sh.ss == 0 && sd.sh.ah == 0
+	needAC := sequential || sd.sh.se > 0
+	for _, c := range sd.sh.components {
+		if needDC && (c.td > 3 || sd.dc[c.td] == nil) || needAC && (c.ta > 3 || sd.ac[c.ta] == nil) {
+			fmt.Fprintf(ps.w, "VERIFY\tscan=%d\tskipped (undefined Huffman table)\n", sd.index)
+			return
+		}
+	}
+	if sd.sh.ss > 0 && sd.sh.ah > 0 {
+		// Refinements rely on the coefficients decoded by earlier scans.
+		for _, c := range sd.sh.components {
+			if n, ok := v.failed[c.id]; ok {
+				fmt.Fprintf(ps.w, "VERIFY\tscan=%d\tskipped (scan %d failed)\n", sd.index, n)
+				return
+			}
+		}
+	}
+	if sd.data.over {
+		ps.problemf(diagLimit, sd.start, "more than %d bytes of entropy-coded data in scan %d", sd.data.limit, sd.index)
+		fmt.Fprintf(ps.w, "VERIFY\tscan=%d\tskipped (over the limit of %d bytes)\n", sd.index, sd.data.limit)
+		for _, c := range sd.sh.components {
+			v.failed[c.id] = sd.index
+		}
+		return
+	}
+	total := v.fr.mcus(sd.sh.ids())
+	dec := &scanDecoding{v: v, sd: sd, br: &bitReader{data: sd.data.Bytes()}}
+	mcu, comp, err := dec.run(total)
+	if rs := ps.lastScan; rs != nil && rs.scan == sd.index {
+		rs.decoded = mcu
+	}
+	if err != nil {
+		// Report the byte holding the bit that could not be decoded.
+		br := dec.br
+		off := sd.start + br.pos
+		if br.n > 0 {
+			off--
+		}
+		ps.problemf(diagCorruptData, off, "scan %d: %v at MCU %d of %d, component %d, bit %d",
+			sd.index, err, mcu, total, comp, 7-br.n)
+		for _, c := range sd.sh.components {
+			v.failed[c.id] = sd.index
+		}
+		fmt.Fprintf(ps.w, "VERIFY\tscan=%d\tmcus=%d/%d\tFAILED at %d: %v\n", sd.index, mcu, total, off, err)
+		return
+	}
+	fmt.Fprintf(ps.w, "VERIFY\tscan=%d\tmcus=%d", sd.index, total)
+	if extra := len(sd.data.Bytes()) - dec.br.pos; extra > 0 {
+		fmt.Fprintf(ps.w, "\textra=%d", extra)
+		// Without a height, the whole scan is left over.
+		if extra > scanPadding && total > 0 {
+			ps.problemf(diagExtraData, sd.start+dec.br.pos, "scan %d: %d bytes of entropy-coded data after the last MCU", sd.index, extra)
+		}
+	}
+	fmt.Fprintf(ps.w, "\tok\n")
+}
+
+// scanDecoding is the state of the decoding of one scan.
+type scanDecoding struct {
+	v      *scanVerifier
+	sd     *scanDecoder
+	br     *bitReader
+	eobrun int
+}
+
+// run decodes mcus MCUs, returning on error the MCU and the component id
+// it failed at.
+func (d *scanDecoding) run(mcus int) (int, int, error) {
+	sh := d.sd.sh
+	for i := 0; i < mcus; i++ {
+		if d.sd.interval > 0 && i > 0 && i%d.sd.interval == 0 {
+			next := RST0 + symbol((i/d.sd.interval-1)%8)
+			if err := d.br.restart(next); err != nil {
+				return i, 0, err
+			}
+			d.eobrun = 0
+		}
+		for _, c := range sh.components {
+			fc := d.v.fr.component(c.id)
+			if fc == nil {
+				return i, c.id, errBadCode
+			}
+			blocks := 1
+			if len(sh.components) > 1 {
+				blocks = fc.h * fc.v
+			}
+			for b := 0; b < blocks; b++ {
+				if err := d.block(c, i); err != nil {
+					return i, c.id, err
+				}
+			}
+		}
+	}
+	return mcus, 0, nil
+}
+
+// block decodes the data of one block of component c. For AC scans of
+// progressive images, which are never interleaved, index is the block
+// index in the component.
+func (d *scanDecoding) block(c scanComponent, index int) error {
+	sh := d.sd.sh
+	br := d.br
+	if d.v.fr.sym&3 != 2 {
+		// Sequential: DC difference then run-length coded AC coefficients.
+		if err := d.dc(c); err != nil {
+			return err
+		}
+		for k := 1; k < 64; k++ {
+			rs, err := br.decode(d.sd.ac[c.ta])
+			if err != nil {
+				return err
+			}
+			r, s := int(rs>>4), int(rs&15)
+			if s == 0 {
+				if r != 15 {
+					return nil
+				}
+				k += 15
+				continue
+			}
+			k += r
+			if k > 63 {
+				return errCoefIndex
+			}
+			v, err := br.bits(s)
+			if err != nil {
+				return err
+			}
+			if d.v.coef != nil {
+				d.v.coef(c.id, k, extend(v, s))
+			}
+		}
+		return nil
+	}
+	switch {
+	case sh.ss == 0 && sh.ah == 0:
+		return d.dc(c)
+	case sh.ss == 0:
+		_, err := br.bit()
+		return err
+	}
+	nz := d.v.nonzero[c.id]
+	if nz == nil {
+		nz = make([]uint64, d.v.fr.mcus([]int{c.id}))
+		d.v.nonzero[c.id] = nz
+	}
+	if index >= len(nz) || sh.se > 63 || sh.ss > sh.se {
+		return errCoefIndex
+	}
+	if sh.ah == 0 {
+		return d.acFirst(c, &nz[index])
+	}
+	return d.acRefine(c, &nz[index])
+}
+
+func (d *scanDecoding) dc(c scanComponent) error {
+	s, err := d.br.decode(d.sd.dc[c.td])
+	if err != nil {
+		return err
+	}
+	if s > 16 {
+		return errBadDC
+	}
+	_, err = d.br.bits(int(s))
+	return err
+}
+
+// acFirst decodes the first scan of a band of AC coefficients (G.1.2.2).
+func (d *scanDecoding) acFirst(c scanComponent, nz *uint64) error {
+	if d.eobrun > 0 {
+		d.eobrun--
+		return nil
+	}
+	sh := d.sd.sh
+	for k := sh.ss; k <= sh.se; k++ {
+		rs, err := d.br.decode(d.sd.ac[c.ta])
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), int(rs&15)
+		if s == 0 {
+			if r != 15 {
+				return d.eob(r)
+			}
+			k += 15
+			continue
+		}
+		k += r
+		if k > sh.se {
+			return errCoefIndex
+		}
+		if _, err := d.br.bits(s); err != nil {
+			return err
+		}
+		*nz |= 1 << uint(k)
+	}
+	return nil
+}
+
+// eob reads the length of an end-of-band run of 2^r bands or more,
+// counting the current one.
+func (d *scanDecoding) eob(r int) error {
+	d.eobrun = 1 << uint(r)
+	if r > 0 {
+		extra, err := d.br.bits(r)
+		if err != nil {
+			return err
+		}
+		d.eobrun += extra
+	}
+	d.eobrun--
+	return nil
+}
+
+// acRefine decodes a refinement scan of a band of AC coefficients
+// (G.1.2.3): coefficients already non-zero get a correction bit, and
+// new ones are placed by counting the zero coefficients they skip.
+func (d *scanDecoding) acRefine(c scanComponent, nz *uint64) error {
+	sh := d.sd.sh
+	k := sh.ss
+	if d.eobrun == 0 {
+		for ; k <= sh.se; k++ {
+			rs, err := d.br.decode(d.sd.ac[c.ta])
+			if err != nil {
+				return err
+			}
+			r, s := int(rs>>4), int(rs&15)
+			if s != 0 {
+				if s != 1 {
+					return errBadRefine
+				}
+				if _, err := d.br.bit(); err != nil {
+					return err
+				}
+			} else if r != 15 {
+				if err := d.eob(r); err != nil {
+					return err
+				}
+				d.eobrun++ // the rest of this band is decoded below
+				break
+			}
+			for ; k <= sh.se; k++ {
+				if *nz&(1<<uint(k)) != 0 {
+					if _, err := d.br.bit(); err != nil {
+						return err
+					}
+					continue
+				}
+				if r == 0 {
+					break
+				}
+				r--
+			}
+			if s != 0 {
+				if k > sh.se {
+					return errCoefIndex
+				}
+				*nz |= 1 << uint(k)
+			}
+		}
+	}
+	if d.eobrun > 0 {
+		for ; k <= sh.se; k++ {
+			if *nz&(1<<uint(k)) != 0 {
+				if _, err := d.br.bit(); err != nil {
+					return err
+				}
+			}
+		}
+		d.eobrun--
+	}
+	return nil
+}
+
+// extend converts the s bits v of a coefficient to its signed value
+// (F.2.2.1 of the standard).
+func extend(v, s int) int {
+	if v < 1<<uint(s-1) {
+		return v - (1<<uint(s) - 1)
+	}
+	return v
+}
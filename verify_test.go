@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// encodeGray returns a baseline JPEG of a w x h gradient, as written by
+// image/jpeg.
+func encodeGray(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{uint8(x*7 + y*13)})
+		}
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 75}); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// sosHeader returns the offset of the payload of the first SOS segment.
+func sosHeader(t *testing.T, data []byte) int {
+	t.Helper()
+	i := bytes.Index(data, []byte{0xff, byte(SOS)})
+	if i < 0 {
+		t.Fatal("no SOS")
+	}
+	return i + 4
+}
+
+// verifyBytes parses data, Huffman-decoding its scans.
+func verifyBytes(data []byte) *parser {
+	ps := newParser(ioutil.Discard)
+	v := newScanVerifier(ps)
+	ps.observers = append(ps.observers, v.observe)
+	ps.parse(bufio.NewReader(bytes.NewReader(data)))
+	v.flush()
+	ps.finish()
+	return ps
+}
+
+// insertBeforeEOI returns data with b inserted before its final EOI, at
+// the end of the entropy-coded data of the last scan.
+func insertBeforeEOI(data, b []byte) []byte {
+	n := len(data) - 2
+	out := append(append([]byte(nil), data[:n]...), b...)
+	return append(out, data[n:]...)
+}
+
+func problemCodes(ps *parser) map[string]bool {
+	codes := make(map[string]bool)
+	for _, p := range ps.problems {
+		codes[p.code] = true
+	}
+	return codes
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(data []byte, sos int) []byte
+		want   []string // problem codes, none if empty
+	}{
+		{
+			name:   "valid",
+			change: func(data []byte, sos int) []byte { return data },
+		},
+		{
+			// A baseline scan coding no AC coefficient with an AC table
+			// that cannot exist.
+			name: "sequential se=0 ta=15",
+			change: func(data []byte, sos int) []byte {
+				data[sos+2] = 0x0f // td=0, ta=15
+				data[sos+4] = 0    // se
+				return data
+			},
+			want: []string{diagBadScan},
+		},
+		{
+			name: "undefined DC table",
+			change: func(data []byte, sos int) []byte {
+				data[sos+2] = 0x30
+				return data
+			},
+			want: []string{diagBadScan},
+		},
+		{
+			name: "marker in scan",
+			change: func(data []byte, sos int) []byte {
+				start := sos + 6
+				data[start+10], data[start+11] = 0xff, byte(RST0+5)
+				return data
+			},
+			want: []string{diagCorruptData},
+		},
+		{
+			name: "padding",
+			change: func(data []byte, sos int) []byte {
+				return insertBeforeEOI(data, []byte{0xff, 0x00, 0x00})
+			},
+		},
+		{
+			name: "leftover data",
+			change: func(data []byte, sos int) []byte {
+				return insertBeforeEOI(data, bytes.Repeat([]byte{0x55}, 64))
+			},
+			want: []string{diagExtraData},
+		},
+		{
+			name: "truncated scan",
+			change: func(data []byte, sos int) []byte {
+				return data[:sos+6+len(data[sos+6:])/2]
+			},
+			want: []string{diagCorruptData, diagTruncated},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeGray(t, 64, 48)
+			data = tt.change(data, sosHeader(t, data))
+			codes := problemCodes(verifyBytes(data))
+			for _, c := range tt.want {
+				if !codes[c] {
+					t.Errorf("no %s problem, got %v", c, codes)
+				}
+			}
+			if len(tt.want) == 0 && len(codes) > 0 {
+				t.Errorf("got problems %v, want none", codes)
+			}
+		})
+	}
+}
+
+func TestTruncatedCompleteness(t *testing.T) {
+	data := encodeGray(t, 256, 256)
+	sos := sosHeader(t, data) + 6
+	data = data[:sos+(len(data)-2-sos)/2]
+	want := "missing EOI, file ends in scan 1 after"
+	if got := parseBytes(data).truncation(); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "completeness unknown without -verify-scan") {
+		t.Errorf("got %q without -verify-scan", got)
+	}
+	got := verifyBytes(data).truncation()
+	var pct int
+	if i := strings.Index(got, "scan about "); i < 0 {
+		t.Fatalf("got %q with -verify-scan, want an estimate", got)
+	} else {
+		fmt.Sscanf(got[i:], "scan about %d%%", &pct)
+	}
+	if pct < 30 || pct > 70 {
+		t.Errorf("got %q, want about half of the scan", got)
+	}
+}